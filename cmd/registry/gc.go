@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"my_docker_registry/internal/storage"
+	"my_docker_registry/internal/types"
+)
+
+// runGarbageCollect 实现 registry garbage-collect 子命令，离线执行标记-清除垃圾回收。
+// 运行期间不应有客户端推送，否则刚上传、尚未被 manifest 引用的 blob 可能被清除。
+func runGarbageCollect(args []string) {
+	flags := flag.NewFlagSet("garbage-collect", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only report what would be deleted")
+	deleteUntagged := flags.Bool("delete-untagged", false, "also delete manifests that are not reachable from any tag")
+	flags.Parse(args)
+
+	storageDriver, err := storage.NewFileSystemDriver(storageRoot, storage.FileSystemOptions{})
+	if err != nil {
+		log.Fatalf("Failed to initialize storage driver: %v", err)
+	}
+	collector, ok := storageDriver.(storage.GarbageCollector)
+	if !ok {
+		log.Fatalf("Storage driver does not support garbage collection")
+	}
+
+	result, err := collector.GarbageCollect(types.GarbageCollectOptions{
+		DryRun:         *dryRun,
+		DeleteUntagged: *deleteUntagged,
+	})
+	if err != nil {
+		log.Fatalf("Garbage collection failed: %v", err)
+	}
+
+	action := "deleted"
+	if *dryRun {
+		action = "eligible for deletion"
+	}
+	for _, manifest := range result.ManifestsDeleted {
+		fmt.Printf("manifest %s: %s\n", action, manifest)
+	}
+	for _, blob := range result.BlobsDeleted {
+		fmt.Printf("blob %s: %s\n", action, blob)
+	}
+	fmt.Printf("%d manifests, %d blobs and %d layer links %s, %s reclaimed\n",
+		len(result.ManifestsDeleted), len(result.BlobsDeleted), result.LayerLinksDeleted, action, formatBytes(result.BytesReclaimed))
+}
+
+// runOnlineGarbageCollect 在服务运行期间每隔 interval 执行一次垃圾回收。
+// 宽限期和存储层的写屏障保证正在推送的镜像不会被回收
+func runOnlineGarbageCollect(collector storage.GarbageCollector, interval time.Duration, options types.GarbageCollectOptions) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		result, err := collector.GarbageCollect(options)
+		if err != nil {
+			log.Printf("Garbage collection failed: %v", err)
+			continue
+		}
+		log.Printf("Garbage collection: %d manifests, %d blobs and %d layer links deleted, %s reclaimed",
+			len(result.ManifestsDeleted), len(result.BlobsDeleted), result.LayerLinksDeleted, formatBytes(result.BytesReclaimed))
+	}
+}
+
+// formatBytes 将字节数格式化为便于阅读的形式，如 1.5 MiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
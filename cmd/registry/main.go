@@ -1,48 +1,98 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
 	"my_docker_registry/internal/handler"
 	"my_docker_registry/internal/storage"
-
-	"github.com/gorilla/mux"
+	"my_docker_registry/internal/types"
 )
 
+// storageRoot 是文件系统存储驱动的根目录
+const storageRoot = "./registry_data"
+
 func main() {
-	// 初始化存储层
-	storageDriver, err := storage.NewFileSystemDriver("./registry_data")
-	if err != nil {
-		log.Fatalf("Failed to initialize storage driver: %v", err)
+	// 子命令
+	if len(os.Args) > 1 && os.Args[1] == "garbage-collect" {
+		runGarbageCollect(os.Args[2:])
+		return
 	}
 
-	// 初始化处理层
-	registryHandler := handler.NewRegistryHandler(storageDriver)
+	// 解析启动参数
+	storageType := flag.String("storage", "filesystem", "storage driver: filesystem, or inmemory for an ephemeral registry")
+	deleteEnabled := flag.Bool("delete-enabled", false, "allow deleting blobs via DELETE /v2/{name}/blobs/{digest}")
+	disableFsync := flag.Bool("disable-fsync", false, "skip fsync on writes (faster, but recent writes may be lost on crash; for development only)")
+	fileLocking := flag.Bool("file-locking", false, "additionally use flock so that multiple registry processes can share the storage root (incompatible with -gc-interval)")
+	gcInterval := flag.Duration("gc-interval", 0, "run online garbage collection at this interval (0 disables it)")
+	gcGracePeriod := flag.Duration("gc-grace-period", time.Hour, "online garbage collection keeps blobs and manifests written within this period")
+	gcDeleteUntagged := flag.Bool("gc-delete-untagged", false, "online garbage collection also deletes manifests not reachable from any tag")
+	uploadPurgeInterval := flag.Duration("upload-purge-interval", 24*time.Hour, "purge expired upload sessions at this interval (0 disables it)")
+	uploadMaxAge := flag.Duration("upload-max-age", 7*24*time.Hour, "upload sessions older than this are purged")
+	flag.Parse()
 
-	// 创建路由器
-	r := mux.NewRouter()
+	// 在线垃圾回收的写屏障只在进程内生效，其他进程在标记之后写入的 manifest 引用的 blob 可能被清除
+	if *gcInterval > 0 && *fileLocking {
+		log.Fatalf("-gc-interval cannot be used with -file-locking: the garbage collection write barrier does not cover other registry processes sharing the storage root")
+	}
 
-	// 基础 API 版本检查
-	r.HandleFunc("/v2/", registryHandler.APIVersionHandler).Methods("GET")
+	// 初始化存储层
+	var storageDriver storage.StorageDriver
+	switch *storageType {
+	case "filesystem":
+		var err error
+		storageDriver, err = storage.NewFileSystemDriver(storageRoot, storage.FileSystemOptions{
+			DisableFsync: *disableFsync,
+			FileLocking:  *fileLocking,
+		})
+		if err != nil {
+			log.Fatalf("Failed to initialize storage driver: %v", err)
+		}
+	case "inmemory":
+		storageDriver = storage.NewInMemoryDriver()
+	default:
+		log.Fatalf("Unknown storage driver: %s", *storageType)
+	}
 
-	// Manifests 相关路由
-	// GET, PUT, HEAD, DELETE /v2/{name}/manifests/{reference}
-	r.HandleFunc("/v2/{name:.+}/manifests/{reference}", registryHandler.ManifestHandler).Methods("GET", "PUT", "HEAD", "DELETE")
+	// 启动在线垃圾回收
+	if *gcInterval > 0 {
+		collector, ok := storageDriver.(storage.GarbageCollector)
+		if !ok {
+			log.Fatalf("Storage driver does not support garbage collection")
+		}
+		go runOnlineGarbageCollect(collector, *gcInterval, types.GarbageCollectOptions{
+			DeleteUntagged: *gcDeleteUntagged,
+			GracePeriod:    *gcGracePeriod,
+		})
+	}
 
-	// Blobs 相关路由
-	// HEAD, GET /v2/{name}/blobs/{digest}
-	r.HandleFunc("/v2/{name:.+}/blobs/{digest}", registryHandler.BlobHandler).Methods("HEAD", "GET")
+	// 启动过期上传会话的清理
+	if *uploadPurgeInterval > 0 {
+		purger, ok := storageDriver.(storage.UploadPurger)
+		if !ok {
+			log.Fatalf("Storage driver does not support purging uploads")
+		}
+		go runUploadPurger(purger, *uploadPurgeInterval, *uploadMaxAge)
+	}
 
-	// POST /v2/{name}/blobs/uploads/
-	r.HandleFunc("/v2/{name:.+}/blobs/uploads/", registryHandler.InitiateBlobUploadHandler).Methods("POST")
+	// 初始化处理层
+	registryHandler := handler.NewRegistryHandler(storageDriver, handler.Options{
+		DeleteEnabled: *deleteEnabled,
+	})
 
-	// GET, PATCH, PUT, DELETE /v2/{name}/blobs/uploads/{uuid}
-	r.HandleFunc("/v2/{name:.+}/blobs/uploads/{uuid}", registryHandler.BlobUploadHandler).Methods("GET", "PATCH", "PUT", "DELETE")
+	// 创建路由器
+	r := handler.NewRouter(registryHandler)
 
 	port := "5000"
 	log.Printf("Starting Docker Registry backend on port %s...", port)
-	log.Printf("Registry data will be stored in: ./registry_data")
+	if *storageType == "inmemory" {
+		log.Printf("Registry data will be kept in memory and lost on exit")
+	} else {
+		log.Printf("Registry data will be stored in: %s", storageRoot)
+	}
 
 	if err := http.ListenAndServe(":"+port, r); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"my_docker_registry/internal/storage"
+	"my_docker_registry/internal/types"
+)
+
+// runUploadPurger 在服务运行期间每隔 interval 清理一次创建时间超过 maxAge 的上传会话
+func runUploadPurger(purger storage.UploadPurger, interval, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		result, err := purger.PurgeUploads(types.PurgeUploadsOptions{MaxAge: maxAge})
+		if err != nil {
+			log.Printf("Purging expired uploads failed: %v", err)
+			continue
+		}
+		if len(result.UploadsPurged) > 0 {
+			log.Printf("Purged %d expired uploads, %s reclaimed", len(result.UploadsPurged), formatBytes(result.BytesReclaimed))
+		}
+	}
+}
@@ -1,12 +1,15 @@
 package handler
 
 import (
+	"encoding/json"
 	"fmt"
-	"io"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"my_docker_registry/internal/storage"
 	"my_docker_registry/internal/types"
@@ -14,15 +17,28 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// 与参考实现 distribution 保持一致的 catalog 分页大小
+const (
+	defaultCatalogEntries = 100
+	maxCatalogEntries     = 1000
+)
+
+// Options 包含 RegistryHandler 的可选配置
+type Options struct {
+	DeleteEnabled bool // 是否允许通过 DELETE /v2/{name}/blobs/{digest} 删除 blob
+}
+
 // RegistryHandler 包含所有 API 端点的处理逻辑
 type RegistryHandler struct {
 	storage storage.StorageDriver
+	options Options
 }
 
 // NewRegistryHandler 创建一个新的 RegistryHandler 实例
-func NewRegistryHandler(storageDriver storage.StorageDriver) *RegistryHandler {
+func NewRegistryHandler(storageDriver storage.StorageDriver, options Options) *RegistryHandler {
 	return &RegistryHandler{
 		storage: storageDriver,
+		options: options,
 	}
 }
 
@@ -39,17 +55,25 @@ func (h *RegistryHandler) writeErrorResponse(w http.ResponseWriter, statusCode i
 	types.WriteErrorResponse(w, statusCode, genericError)
 }
 
+// writeRangeNotSatisfiable 返回 416 Range Not Satisfiable，用于数据块的起始位置与会话当前偏移量冲突
+// （如客户端重试导致重复或乱序的 PATCH）。同时返回会话当前的 Location 和 Range，客户端可据此从正确的位置续传
+func (h *RegistryHandler) writeRangeNotSatisfiable(w http.ResponseWriter, name, uuid string, regErr types.RegistryError) {
+	status, err := h.storage.GetBlobUploadStatus(types.GetBlobParams{RepositoryName: name, UUID: uuid})
+	if err == nil {
+		w.Header().Set("Location", status.Location)
+		w.Header().Set("Range", status.Range)
+		w.Header().Set("Docker-Upload-UUID", status.UUID)
+	}
+	types.WriteErrorResponse(w, http.StatusRequestedRangeNotSatisfiable, regErr)
+}
+
 // parseContentRange 解析 Content-Range 头部
 func parseContentRange(rangeHeader string) (int64, int64, error) {
 	if rangeHeader == "" {
 		return 0, 0, fmt.Errorf("missing Content-Range header")
 	}
 
-	// Content-Range: bytes 0-65535
-	if !strings.HasPrefix(rangeHeader, "bytes ") {
-		return 0, 0, fmt.Errorf("invalid Content-Range format")
-	}
-
+	// Content-Range: 0-65535，兼容带 bytes 前缀的写法
 	rangeStr := strings.TrimPrefix(rangeHeader, "bytes ")
 	parts := strings.Split(rangeStr, "-")
 	if len(parts) != 2 {
@@ -66,9 +90,40 @@ func parseContentRange(rangeHeader string) (int64, int64, error) {
 		return 0, 0, fmt.Errorf("invalid range end: %v", err)
 	}
 
+	if start < 0 || end < start {
+		return 0, 0, fmt.Errorf("invalid range: %s", rangeStr)
+	}
+
 	return start, end, nil
 }
 
+// parsePagination 解析分页查询参数 n 和 last，n 缺省时返回 defaultN
+func parsePagination(r *http.Request, defaultN int) (int, string, error) {
+	query := r.URL.Query()
+	last := query.Get("last")
+
+	nStr := query.Get("n")
+	if nStr == "" {
+		return defaultN, last, nil
+	}
+
+	n, err := strconv.Atoi(nStr)
+	if err != nil || n < 0 {
+		return 0, "", types.NewPaginationInvalidError(nStr)
+	}
+	return n, last, nil
+}
+
+// setNextLink 按 RFC 5988 设置指向下一页的 Link 头部
+func setNextLink(w http.ResponseWriter, path string, n int, last string) {
+	query := url.Values{}
+	query.Set("last", last)
+	if n >= 0 {
+		query.Set("n", strconv.Itoa(n))
+	}
+	w.Header().Set("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", path, query.Encode()))
+}
+
 // API version check handler
 func (h *RegistryHandler) APIVersionHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
@@ -128,8 +183,21 @@ func (h *RegistryHandler) getManifest(w http.ResponseWriter, r *http.Request, na
 		return
 	}
 
-	// 设置响应头 - 使用检测到的 Content-Type
+	// 根据 Accept 头部协商返回的 manifest
+	manifestResponse, err = h.negotiateManifest(r, name, reference, manifestResponse)
+	if err != nil {
+		if regErr, ok := err.(types.RegistryError); ok {
+			// 404 Manifest not acceptable by client
+			types.WriteErrorResponse(w, http.StatusNotFound, regErr)
+		} else {
+			h.writeErrorResponse(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	// 设置响应头 - 使用保存的或检测到的 Content-Type
 	w.Header().Set("Content-Type", manifestResponse.MediaType)
+	w.Header().Set("Vary", "Accept")
 
 	// Docker-Content-Digest 应该是 manifest 内容本身的摘要，而不是 config 的摘要
 	manifestDigest := types.CalculateDigest(manifestResponse.Content)
@@ -142,19 +210,26 @@ func (h *RegistryHandler) getManifest(w http.ResponseWriter, r *http.Request, na
 
 // putManifest 处理 PUT /v2/{name}/manifests/{reference}
 func (h *RegistryHandler) putManifest(w http.ResponseWriter, r *http.Request, name, reference string) {
-	// 读取请求体
-	content, err := io.ReadAll(r.Body)
-	if err != nil {
-		types.WriteErrorResponse(w, http.StatusBadRequest,
-			types.NewManifestInvalidError("Failed to read manifest"))
-		return
-	}
 	defer r.Body.Close()
 
+	// 客户端提供的 Content-Type 是权威的媒体类型，去掉 charset 等参数
+	var mediaType string
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			types.WriteErrorResponse(w, http.StatusBadRequest,
+				types.NewManifestInvalidError(fmt.Sprintf("invalid Content-Type: %s", contentType)))
+			return
+		}
+		mediaType = parsed
+	}
+
+	// 请求体交给存储层按上限读取
 	params := types.PutManifestParams{
 		RepositoryName: name,
 		Reference:      reference,
-		Content:        content,
+		MediaType:      mediaType,
+		Body:           r.Body,
 	}
 
 	result, err := h.storage.PutManifest(params)
@@ -164,8 +239,8 @@ func (h *RegistryHandler) putManifest(w http.ResponseWriter, r *http.Request, na
 			case types.ErrorCodeNameUnknown:
 				// 404 Repository not found
 				types.WriteErrorResponse(w, http.StatusNotFound, types.NewNameUnknownError(name))
-			case types.ErrorCodeNameInvalid, types.ErrorCodeManifestInvalid:
-				// 400 Invalid name, reference, or manifest
+			case types.ErrorCodeNameInvalid, types.ErrorCodeManifestInvalid, types.ErrorCodeManifestBlobUnknown:
+				// 400 Invalid name, reference, or manifest, or unknown blobs referenced by it
 				types.WriteErrorResponse(w, http.StatusBadRequest, regErr)
 			default:
 				types.WriteErrorResponse(w, http.StatusBadRequest, regErr)
@@ -179,6 +254,10 @@ func (h *RegistryHandler) putManifest(w http.ResponseWriter, r *http.Request, na
 	// 设置响应头
 	w.Header().Set("Location", result.Location)
 	w.Header().Set("Docker-Content-Digest", result.Digest)
+	if result.Subject != "" {
+		// 告知客户端 registry 已处理 subject 字段，无需再维护 referrers tag
+		w.Header().Set("OCI-Subject", result.Subject)
+	}
 	w.WriteHeader(http.StatusCreated)
 }
 
@@ -210,9 +289,33 @@ func (h *RegistryHandler) headManifest(w http.ResponseWriter, r *http.Request, n
 	if contentType == "" {
 		contentType = types.ManifestV2MediaType // 默认值
 	}
+	digest := manifestData.Digest
+	contentLength := manifestData.ContentLength
+
+	// 客户端不接受存储的媒体类型时，与 GET 使用相同的协商逻辑
+	if negotiableMediaType(contentType) && !acceptsMediaType(parseAccept(r), contentType) {
+		manifestResponse, err := h.storage.GetManifest(params)
+		if err == nil {
+			manifestResponse, err = h.negotiateManifest(r, name, reference, manifestResponse)
+		}
+		if err != nil {
+			if regErr, ok := err.(types.RegistryError); ok {
+				// 404 Manifest not acceptable by client
+				types.WriteErrorResponse(w, http.StatusNotFound, regErr)
+			} else {
+				h.writeErrorResponse(w, http.StatusInternalServerError, err)
+			}
+			return
+		}
+		contentType = manifestResponse.MediaType
+		digest = types.CalculateDigest(manifestResponse.Content)
+		contentLength = len(manifestResponse.Content)
+	}
+
 	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Docker-Content-Digest", manifestData.Digest)
-	w.Header().Set("Content-Length", strconv.Itoa(manifestData.ContentLength))
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", strconv.Itoa(contentLength))
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -243,9 +346,132 @@ func (h *RegistryHandler) deleteManifest(w http.ResponseWriter, r *http.Request,
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// === Referrers Handlers ===
+
+// ReferrersHandler 处理 GET /v2/{name}/referrers/{digest}
+func (h *RegistryHandler) ReferrersHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	digest := vars["digest"]
+
+	params := types.GetReferrersParams{
+		RepositoryName: name,
+		Digest:         digest,
+	}
+
+	index, err := h.storage.GetReferrers(params)
+	if err != nil {
+		if regErr, ok := err.(types.RegistryError); ok {
+			switch regErr.Code {
+			case types.ErrorCodeNameUnknown:
+				// 404 Repository not found
+				types.WriteErrorResponse(w, http.StatusNotFound, types.NewNameUnknownError(name))
+			default:
+				types.WriteErrorResponse(w, http.StatusBadRequest, regErr)
+			}
+		} else {
+			h.writeErrorResponse(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	// 按 artifactType 过滤，并告知客户端已应用的过滤条件
+	if artifactType := r.URL.Query().Get("artifactType"); artifactType != "" {
+		filtered := []types.ManifestListDescriptor{}
+		for _, descriptor := range index.Manifests {
+			if descriptor.ArtifactType == artifactType {
+				filtered = append(filtered, descriptor)
+			}
+		}
+		index.Manifests = filtered
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+
+	w.Header().Set("Content-Type", types.OCIIndexMediaType)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(index)
+}
+
+// === Tag Handlers ===
+
+// TagsListHandler 处理 GET /v2/{name}/tags/list
+func (h *RegistryHandler) TagsListHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	n, last, err := parsePagination(r, -1)
+	if err != nil {
+		types.WriteErrorResponse(w, http.StatusBadRequest, err.(types.RegistryError))
+		return
+	}
+
+	params := types.ListTagsParams{
+		RepositoryName: name,
+		N:              n,
+		Last:           last,
+	}
+
+	tagsList, err := h.storage.ListTags(params)
+	if err != nil {
+		if regErr, ok := err.(types.RegistryError); ok {
+			switch regErr.Code {
+			case types.ErrorCodeNameUnknown:
+				// 404 Repository not found
+				types.WriteErrorResponse(w, http.StatusNotFound, types.NewNameUnknownError(name))
+			default:
+				types.WriteErrorResponse(w, http.StatusBadRequest, regErr)
+			}
+		} else {
+			h.writeErrorResponse(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	// 还有下一页时设置 Link 头部
+	if tagsList.HasMore && len(tagsList.Tags) > 0 {
+		setNextLink(w, fmt.Sprintf("/v2/%s/tags/list", name), n, tagsList.Tags[len(tagsList.Tags)-1])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tagsList)
+}
+
+// === Catalog Handlers ===
+
+// CatalogHandler 处理 GET /v2/_catalog
+func (h *RegistryHandler) CatalogHandler(w http.ResponseWriter, r *http.Request) {
+	n, last, err := parsePagination(r, defaultCatalogEntries)
+	if err == nil && n > maxCatalogEntries {
+		err = types.NewPaginationInvalidError(strconv.Itoa(n))
+	}
+	if err != nil {
+		types.WriteErrorResponse(w, http.StatusBadRequest, err.(types.RegistryError))
+		return
+	}
+
+	params := types.ListRepositoriesParams{
+		N:    n,
+		Last: last,
+	}
+
+	catalog, err := h.storage.ListRepositories(params)
+	if err != nil {
+		h.writeErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// 还有下一页时设置 Link 头部
+	if catalog.HasMore && len(catalog.Repositories) > 0 {
+		setNextLink(w, "/v2/_catalog", n, catalog.Repositories[len(catalog.Repositories)-1])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(catalog)
+}
+
 // === Blob Handlers ===
 
-// BlobHandler 处理 blob 相关的请求 (HEAD, GET)
+// BlobHandler 处理 blob 相关的请求 (HEAD, GET, DELETE)
 func (h *RegistryHandler) BlobHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name := vars["name"]
@@ -256,6 +482,8 @@ func (h *RegistryHandler) BlobHandler(w http.ResponseWriter, r *http.Request) {
 		h.headBlob(w, r, name, digest)
 	case http.MethodGet:
 		h.getBlob(w, r, name, digest)
+	case http.MethodDelete:
+		h.deleteBlob(w, r, name, digest)
 	default:
 		h.writeErrorResponse(w, http.StatusMethodNotAllowed,
 			types.NewError(types.ErrorCodeUnsupported, "Method not allowed", nil))
@@ -288,6 +516,8 @@ func (h *RegistryHandler) headBlob(w http.ResponseWriter, r *http.Request, name,
 	// 设置响应头
 	w.Header().Set("Content-Length", strconv.Itoa(status.ContentLength))
 	w.Header().Set("Docker-Content-Digest", status.Digest)
+	w.Header().Set("ETag", fmt.Sprintf("%q", status.Digest))
+	w.Header().Set("Accept-Ranges", "bytes")
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -314,17 +544,68 @@ func (h *RegistryHandler) getBlob(w http.ResponseWriter, r *http.Request, name,
 		return
 	}
 
-	// 设置响应头
-	w.Header().Set("Content-Length", strconv.Itoa(status.ContentLength))
+	if status.Reader == nil {
+		h.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Errorf("invalid response from storage layer"))
+		return
+	}
+	defer status.Reader.Close()
+
+	// 不支持 multipart/byteranges，多个范围直接返回 416
+	if rangeHeader := r.Header.Get("Range"); strings.Contains(rangeHeader, ",") {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", status.ContentLength))
+		types.WriteErrorResponse(w, http.StatusRequestedRangeNotSatisfiable,
+			types.NewRangeInvalidError(rangeHeader))
+		return
+	}
+
+	// 设置响应头，blob 内容不可变，ETag 即为摘要
 	w.Header().Set("Content-Type", status.ContentType)
 	w.Header().Set("Docker-Content-Digest", status.Digest)
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("ETag", fmt.Sprintf("%q", status.Digest))
+	w.Header().Set("Cache-Control", "max-age=31536000")
+
+	// 由 http.ServeContent 处理 Range、If-None-Match、If-Range 等条件请求，
+	// 以及 206 Partial Content / 304 Not Modified 响应
+	http.ServeContent(w, r, "", time.Time{}, status.Reader)
+}
+
+// deleteBlob 处理 DELETE /v2/{name}/blobs/{digest}
+func (h *RegistryHandler) deleteBlob(w http.ResponseWriter, r *http.Request, name, digest string) {
+	// 未启用删除时返回 405 UNSUPPORTED
+	if !h.options.DeleteEnabled {
+		types.WriteErrorResponse(w, http.StatusMethodNotAllowed,
+			types.NewError(types.ErrorCodeUnsupported, "The operation is unsupported.", nil))
+		return
+	}
+
+	params := types.GetBlobParams{
+		RepositoryName: name,
+		Digest:         digest,
+	}
 
-	// 实际输出文件内容
-	if status.Reader != nil {
-		defer status.Reader.Close()
-		io.Copy(w, status.Reader)
+	err := h.storage.DeleteBlob(params)
+	if err != nil {
+		if regErr, ok := err.(types.RegistryError); ok {
+			switch regErr.Code {
+			case types.ErrorCodeBlobUnknown:
+				// 404 Blob not found
+				types.WriteErrorResponse(w, http.StatusNotFound, types.NewBlobUnknownError(digest))
+			case types.ErrorCodeDigestInvalid:
+				// 400 Invalid digest
+				types.WriteErrorResponse(w, http.StatusBadRequest, regErr)
+			default:
+				types.WriteErrorResponse(w, http.StatusNotFound, regErr)
+			}
+		} else {
+			h.writeErrorResponse(w, http.StatusInternalServerError, err)
+		}
+		return
 	}
+
+	// 成功删除，返回 202 Accepted
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusAccepted)
 }
 
 // InitiateBlobUploadHandler 处理 POST /v2/{name}/blobs/uploads/
@@ -335,11 +616,15 @@ func (h *RegistryHandler) InitiateBlobUploadHandler(w http.ResponseWriter, r *ht
 	// 获取查询参数
 	mount := r.URL.Query().Get("mount")
 	from := r.URL.Query().Get("from")
+	digest := r.URL.Query().Get("digest")
+	defer r.Body.Close()
 
 	params := types.InitiateBlobUploadParams{
 		RepositoryName: name,
 		Mount:          mount,
 		From:           from,
+		Digest:         digest,
+		Body:           r.Body,
 	}
 
 	response, err := h.storage.InitiateBlobUpload(params)
@@ -349,6 +634,9 @@ func (h *RegistryHandler) InitiateBlobUploadHandler(w http.ResponseWriter, r *ht
 			case types.ErrorCodeNameUnknown:
 				// 404 Repository not found
 				types.WriteErrorResponse(w, http.StatusNotFound, types.NewNameUnknownError(name))
+			case types.ErrorCodeDigestInvalid:
+				// 400 Digest of monolithic upload did not match
+				types.WriteErrorResponse(w, http.StatusBadRequest, types.NewDigestInvalidError(digest))
 			default:
 				h.writeErrorResponse(w, http.StatusInternalServerError, regErr)
 			}
@@ -364,6 +652,11 @@ func (h *RegistryHandler) InitiateBlobUploadHandler(w http.ResponseWriter, r *ht
 		w.Header().Set("Location", response.MountedStatus.Location)
 		w.Header().Set("Docker-Content-Digest", response.MountedStatus.Digest)
 		w.WriteHeader(http.StatusCreated)
+	} else if response.CompletedStatus != nil {
+		// 201 Created - 单请求上传完成
+		w.Header().Set("Location", response.CompletedStatus.Location)
+		w.Header().Set("Docker-Content-Digest", response.CompletedStatus.Digest)
+		w.WriteHeader(http.StatusCreated)
 	} else if response.InitiatedStatus != nil {
 		// 202 Accepted - 上传会话创建
 		w.Header().Set("Location", response.InitiatedStatus.Location)
@@ -430,33 +723,30 @@ func (h *RegistryHandler) getBlobUploadStatus(w http.ResponseWriter, r *http.Req
 // uploadBlobChunk 处理 PATCH /v2/{name}/blobs/uploads/{uuid}
 func (h *RegistryHandler) uploadBlobChunk(w http.ResponseWriter, r *http.Request, name, uuid string) {
 	// 读取 Content-Range 头
+	// Docker daemon 和 containerd 会用一个不带 Content-Range 的 PATCH 流式上传整个 layer，
+	// 这种情况下直接追加到当前偏移量，只有带了该头部时才校验范围
 	contentRange := r.Header.Get("Content-Range")
-	if contentRange == "" {
-		types.WriteErrorResponse(w, http.StatusBadRequest,
-			types.NewRangeInvalidError("Content-Range header required"))
-		return
-	}
-
-	rangeFrom, rangeTo, err := parseContentRange(contentRange)
-	if err != nil {
-		types.WriteErrorResponse(w, http.StatusBadRequest,
-			types.NewRangeInvalidError(contentRange))
-		return
+	hasRange := contentRange != ""
+
+	var rangeFrom, rangeTo int64
+	if hasRange {
+		var err error
+		rangeFrom, rangeTo, err = parseContentRange(contentRange)
+		if err != nil {
+			types.WriteErrorResponse(w, http.StatusBadRequest,
+				types.NewRangeInvalidError(contentRange))
+			return
+		}
 	}
 
-	// 读取请求体
-	content, err := io.ReadAll(r.Body)
-	if err != nil {
-		types.WriteErrorResponse(w, http.StatusBadRequest,
-			types.NewBlobUploadInvalidError("Failed to read chunk data"))
-		return
-	}
 	defer r.Body.Close()
 
+	// 请求体直接流式写入磁盘，不在内存中缓存
 	params := types.UploadBlobChunkParams{
 		RepositoryName: name,
 		UUID:           uuid,
-		Content:        content,
+		Body:           r.Body,
+		HasRange:       hasRange,
 		RangeFrom:      rangeFrom,
 		RangeTo:        rangeTo,
 	}
@@ -469,8 +759,8 @@ func (h *RegistryHandler) uploadBlobChunk(w http.ResponseWriter, r *http.Request
 				// 404 Upload session not found
 				types.WriteErrorResponse(w, http.StatusNotFound, types.NewBlobUploadUnknownError(uuid))
 			case types.ErrorCodeRangeInvalid:
-				// 400 Malformed content or range
-				types.WriteErrorResponse(w, http.StatusBadRequest, regErr)
+				// 416 Chunk conflicts with the current upload offset
+				h.writeRangeNotSatisfiable(w, name, uuid, regErr)
 			default:
 				types.WriteErrorResponse(w, http.StatusBadRequest, regErr)
 			}
@@ -497,18 +787,32 @@ func (h *RegistryHandler) completeBlobUpload(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// 读取请求体数据
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		h.writeErrorResponse(w, http.StatusBadRequest, err)
-		return
+	// 最后一块数据可以带有可选的 Content-Range
+	contentRange := r.Header.Get("Content-Range")
+	hasRange := contentRange != ""
+
+	var rangeFrom, rangeTo int64
+	if hasRange {
+		var err error
+		rangeFrom, rangeTo, err = parseContentRange(contentRange)
+		if err != nil {
+			types.WriteErrorResponse(w, http.StatusBadRequest,
+				types.NewRangeInvalidError(contentRange))
+			return
+		}
 	}
 
+	defer r.Body.Close()
+
+	// 请求体可能携带最后一块数据，交给存储层流式追加
 	params := types.CompleteBlobUploadParams{
 		RepositoryName: name,
 		UUID:           uuid,
 		Digest:         digest,
-		Data:           body,
+		Body:           r.Body,
+		HasRange:       hasRange,
+		RangeFrom:      rangeFrom,
+		RangeTo:        rangeTo,
 	}
 
 	response, err := h.storage.CompleteBlobUpload(params)
@@ -521,6 +825,9 @@ func (h *RegistryHandler) completeBlobUpload(w http.ResponseWriter, r *http.Requ
 			case types.ErrorCodeDigestInvalid:
 				// 400 Invalid digest or missing parameters
 				types.WriteErrorResponse(w, http.StatusBadRequest, types.NewDigestInvalidError(digest))
+			case types.ErrorCodeRangeInvalid:
+				// 416 Final chunk conflicts with the current upload offset
+				h.writeRangeNotSatisfiable(w, name, uuid, regErr)
 			default:
 				types.WriteErrorResponse(w, http.StatusBadRequest, regErr)
 			}
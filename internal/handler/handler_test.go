@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"my_docker_registry/internal/storage"
+	"my_docker_registry/internal/types"
+)
+
+// newTestRouter 创建使用内存存储驱动的路由器
+func newTestRouter() http.Handler {
+	return NewRouter(NewRegistryHandler(storage.NewInMemoryDriver(), Options{DeleteEnabled: true}))
+}
+
+// do 发送请求并返回响应记录
+func do(t *testing.T, router http.Handler, method, path string, headers map[string]string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// expectStatus 检查响应码
+func expectStatus(t *testing.T, rec *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, want, rec.Body.String())
+	}
+}
+
+// pushBlob 以单请求上传的方式推送 blob，返回其摘要
+func pushBlob(t *testing.T, router http.Handler, repo, data string) string {
+	t.Helper()
+	digest := types.CalculateDigest([]byte(data))
+	rec := do(t, router, "POST", fmt.Sprintf("/v2/%s/blobs/uploads/?digest=%s", repo, digest), nil, data)
+	expectStatus(t, rec, http.StatusCreated)
+	return digest
+}
+
+// pushManifest 推送 manifest，返回其摘要
+func pushManifest(t *testing.T, router http.Handler, repo, reference, mediaType, content string) string {
+	t.Helper()
+	rec := do(t, router, "PUT", fmt.Sprintf("/v2/%s/manifests/%s", repo, reference), map[string]string{"Content-Type": mediaType}, content)
+	expectStatus(t, rec, http.StatusCreated)
+	return rec.Header().Get("Docker-Content-Digest")
+}
+
+// imageManifest 推送 config 和一个 layer，返回引用它们的 OCI 镜像清单
+func imageManifest(t *testing.T, router http.Handler, repo, config, layer string) string {
+	t.Helper()
+	configDigest := pushBlob(t, router, repo, config)
+	layerDigest := pushBlob(t, router, repo, layer)
+	return fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":%q,"size":%d},"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","digest":%q,"size":%d}]}`,
+		types.OCIManifestMediaType, configDigest, len(config), layerDigest, len(layer))
+}
+
+func TestChunkedUploadRejectsConflictingOffset(t *testing.T) {
+	router := newTestRouter()
+
+	// 1. 创建上传会话
+	rec := do(t, router, "POST", "/v2/library/app/blobs/uploads/", nil, "")
+	expectStatus(t, rec, http.StatusAccepted)
+	location := rec.Header().Get("Location")
+
+	// 2. 上传第一块
+	rec = do(t, router, "PATCH", location, map[string]string{"Content-Range": "0-4"}, "hello")
+	expectStatus(t, rec, http.StatusAccepted)
+	if got := rec.Header().Get("Range"); got != "0-4" {
+		t.Fatalf("Range = %q, want 0-4", got)
+	}
+
+	// 3. 重复上传同一块返回 416，并告知当前偏移量
+	rec = do(t, router, "PATCH", location, map[string]string{"Content-Range": "0-4"}, "hello")
+	expectStatus(t, rec, http.StatusRequestedRangeNotSatisfiable)
+	if got := rec.Header().Get("Range"); got != "0-4" {
+		t.Fatalf("Range after conflict = %q, want 0-4", got)
+	}
+	if got := rec.Header().Get("Location"); got != location {
+		t.Fatalf("Location after conflict = %q, want %q", got, location)
+	}
+
+	// 4. 从正确的偏移量续传并完成上传
+	rec = do(t, router, "PATCH", location, map[string]string{"Content-Range": "5-9"}, "world")
+	expectStatus(t, rec, http.StatusAccepted)
+	digest := types.CalculateDigest([]byte("helloworld"))
+	rec = do(t, router, "PUT", location+"?digest="+digest, nil, "")
+	expectStatus(t, rec, http.StatusCreated)
+
+	rec = do(t, router, "GET", "/v2/library/app/blobs/"+digest, nil, "")
+	expectStatus(t, rec, http.StatusOK)
+	if got := rec.Body.String(); got != "helloworld" {
+		t.Fatalf("blob = %q, want helloworld", got)
+	}
+}
+
+func TestDeleteManifestByTagAndDigest(t *testing.T) {
+	router := newTestRouter()
+	repo := "library/app"
+	manifest := imageManifest(t, router, repo, `{"architecture":"amd64","os":"linux"}`, "layer")
+	digest := pushManifest(t, router, repo, "latest", types.OCIManifestMediaType, manifest)
+	pushManifest(t, router, repo, "v1", types.OCIManifestMediaType, manifest)
+
+	// 1. 按 tag 删除只移除该 tag，指向同一 manifest 的其他 tag 不受影响
+	expectStatus(t, do(t, router, "DELETE", "/v2/"+repo+"/manifests/latest", nil, ""), http.StatusAccepted)
+	expectStatus(t, do(t, router, "GET", "/v2/"+repo+"/manifests/latest", nil, ""), http.StatusNotFound)
+	expectStatus(t, do(t, router, "GET", "/v2/"+repo+"/manifests/v1", nil, ""), http.StatusOK)
+	expectStatus(t, do(t, router, "GET", "/v2/"+repo+"/manifests/"+digest, nil, ""), http.StatusOK)
+
+	// 2. 按 digest 删除 manifest 及所有指向它的 tag
+	expectStatus(t, do(t, router, "DELETE", "/v2/"+repo+"/manifests/"+digest, nil, ""), http.StatusAccepted)
+	expectStatus(t, do(t, router, "GET", "/v2/"+repo+"/manifests/"+digest, nil, ""), http.StatusNotFound)
+	expectStatus(t, do(t, router, "GET", "/v2/"+repo+"/manifests/v1", nil, ""), http.StatusNotFound)
+
+	rec := do(t, router, "GET", "/v2/"+repo+"/tags/list", nil, "")
+	expectStatus(t, rec, http.StatusOK)
+	var tags types.TagsList
+	if err := json.Unmarshal(rec.Body.Bytes(), &tags); err != nil {
+		t.Fatal(err)
+	}
+	if len(tags.Tags) != 0 {
+		t.Fatalf("tags = %v, want none", tags.Tags)
+	}
+}
+
+func TestReferrersAndFallbackTag(t *testing.T) {
+	router := newTestRouter()
+	repo := "library/app"
+	subject := pushManifest(t, router, repo, "latest", types.OCIManifestMediaType,
+		imageManifest(t, router, repo, `{"architecture":"amd64","os":"linux"}`, "layer"))
+
+	// 1. 推送以 subject 为 subject 的签名
+	emptyDigest := pushBlob(t, router, repo, "{}")
+	signature := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"artifactType":"application/vnd.example.sig","config":{"mediaType":"application/vnd.oci.empty.v1+json","digest":%q,"size":2},"layers":[],"subject":{"mediaType":%q,"digest":%q,"size":1}}`,
+		types.OCIManifestMediaType, emptyDigest, types.OCIManifestMediaType, subject)
+	signatureDigest := types.CalculateDigest([]byte(signature))
+	rec := do(t, router, "PUT", "/v2/"+repo+"/manifests/"+signatureDigest, map[string]string{"Content-Type": types.OCIManifestMediaType}, signature)
+	expectStatus(t, rec, http.StatusCreated)
+	if got := rec.Header().Get("OCI-Subject"); got != subject {
+		t.Fatalf("OCI-Subject = %q, want %q", got, subject)
+	}
+
+	// 2. referrers API 返回签名的描述符
+	referrers := func(query string) types.ManifestList {
+		t.Helper()
+		rec := do(t, router, "GET", "/v2/"+repo+"/referrers/"+subject+query, nil, "")
+		expectStatus(t, rec, http.StatusOK)
+		var index types.ManifestList
+		if err := json.Unmarshal(rec.Body.Bytes(), &index); err != nil {
+			t.Fatal(err)
+		}
+		return index
+	}
+	index := referrers("")
+	if len(index.Manifests) != 1 || index.Manifests[0].Digest != signatureDigest || index.Manifests[0].ArtifactType != "application/vnd.example.sig" {
+		t.Fatalf("referrers = %+v, want the signature", index.Manifests)
+	}
+	if index := referrers("?artifactType=application/vnd.example.sbom"); len(index.Manifests) != 0 {
+		t.Fatalf("filtered referrers = %+v, want none", index.Manifests)
+	}
+
+	// 3. sha256-<hex> tag 指向同样内容的 index
+	fallbackTag := strings.Replace(subject, ":", "-", 1)
+	rec = do(t, router, "GET", "/v2/"+repo+"/manifests/"+fallbackTag, map[string]string{"Accept": types.OCIIndexMediaType}, "")
+	expectStatus(t, rec, http.StatusOK)
+	var fallback types.ManifestList
+	if err := json.Unmarshal(rec.Body.Bytes(), &fallback); err != nil {
+		t.Fatal(err)
+	}
+	if len(fallback.Manifests) != 1 || fallback.Manifests[0].Digest != signatureDigest {
+		t.Fatalf("fallback index = %+v, want the signature", fallback.Manifests)
+	}
+
+	// 4. 删除签名后 referrers 为空，sha256-<hex> tag 被删除
+	expectStatus(t, do(t, router, "DELETE", "/v2/"+repo+"/manifests/"+signatureDigest, nil, ""), http.StatusAccepted)
+	if index := referrers(""); len(index.Manifests) != 0 {
+		t.Fatalf("referrers after delete = %+v, want none", index.Manifests)
+	}
+	expectStatus(t, do(t, router, "GET", "/v2/"+repo+"/manifests/"+fallbackTag, nil, ""), http.StatusNotFound)
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"my_docker_registry/internal/types"
+)
+
+// acceptRange 是 Accept 头部中的一项，如 application/vnd.oci.image.index.v1+json;q=0.8
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept 解析请求中所有的 Accept 头部，无法解析的项会被忽略
+func parseAccept(r *http.Request) []acceptRange {
+	var ranges []acceptRange
+	for _, header := range r.Header.Values("Accept") {
+		for _, item := range strings.Split(header, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+
+			mediaType, params, err := mime.ParseMediaType(item)
+			if err != nil {
+				continue
+			}
+
+			q := 1.0
+			if qStr, ok := params["q"]; ok {
+				q, err = strconv.ParseFloat(qStr, 64)
+				if err != nil || q < 0 || q > 1 {
+					continue
+				}
+			}
+			ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+		}
+	}
+	return ranges
+}
+
+// acceptsMediaType 判断 Accept 是否接受 mediaType。
+// 按 RFC 7231 由最具体的匹配项（完全匹配 > type/* > */*）决定 q 值，q 为 0 表示拒绝。
+func acceptsMediaType(ranges []acceptRange, mediaType string) bool {
+	// 没有 Accept 头部等同于接受任何类型
+	if len(ranges) == 0 {
+		return true
+	}
+
+	mainType, _, _ := strings.Cut(mediaType, "/")
+	bestSpecificity, bestQ := -1, 0.0
+	for _, ar := range ranges {
+		specificity := -1
+		switch {
+		case ar.mediaType == mediaType:
+			specificity = 2
+		case ar.mediaType == mainType+"/*":
+			specificity = 1
+		case ar.mediaType == "*/*":
+			specificity = 0
+		}
+		if specificity > bestSpecificity {
+			bestSpecificity, bestQ = specificity, ar.q
+		}
+	}
+	return bestSpecificity >= 0 && bestQ > 0
+}
+
+// negotiableMediaType 判断 mediaType 是否参与内容协商。
+// 只有已知的 manifest 格式才可能让旧客户端无法解析，客户端自定义的 artifact 类型原样返回。
+func negotiableMediaType(mediaType string) bool {
+	return types.IsImageManifest(mediaType) || types.IsManifestList(mediaType)
+}
+
+// negotiateManifest 根据 Accept 头部决定返回给客户端的 manifest。
+//   - 客户端接受存储的媒体类型时直接返回；
+//   - 存储的是 manifest list / OCI index 而客户端不接受，且 reference 为 tag 时，
+//     返回其中 linux/amd64（没有则第一个）子 manifest，前提是客户端接受该子 manifest 的类型；
+//   - 否则返回 MANIFEST_UNKNOWN，避免客户端拿到无法解析的内容。
+func (h *RegistryHandler) negotiateManifest(r *http.Request, name, reference string, manifest *types.ManifestResponse) (*types.ManifestResponse, error) {
+	ranges := parseAccept(r)
+	if !negotiableMediaType(manifest.MediaType) || acceptsMediaType(ranges, manifest.MediaType) {
+		return manifest, nil
+	}
+
+	notAcceptable := types.NewError(types.ErrorCodeManifestUnknown,
+		fmt.Sprintf("%s found, but accept header does not support it", manifest.MediaType),
+		map[string]string{"name": name, "reference": reference})
+
+	// 按 digest 拉取时客户端要求的是确定的内容，不能替换成其他 manifest
+	if manifest.ManifestList == nil || strings.HasPrefix(reference, "sha256:") {
+		return nil, notAcceptable
+	}
+
+	child := defaultPlatformManifest(manifest.ManifestList)
+	if child == nil {
+		return nil, notAcceptable
+	}
+
+	childManifest, err := h.storage.GetManifest(types.GetManifestParams{
+		RepositoryName: name,
+		Reference:      child.Digest,
+	})
+	if err != nil {
+		if regErr, ok := err.(types.RegistryError); ok && regErr.Code == types.ErrorCodeManifestUnknown {
+			return nil, notAcceptable
+		}
+		return nil, err
+	}
+	if !acceptsMediaType(ranges, childManifest.MediaType) {
+		return nil, notAcceptable
+	}
+	return childManifest, nil
+}
+
+// defaultPlatformManifest 返回列表中 linux/amd64 平台的子 manifest，没有则返回第一个
+func defaultPlatformManifest(manifestList *types.ManifestList) *types.ManifestListDescriptor {
+	if len(manifestList.Manifests) == 0 {
+		return nil
+	}
+	for i, descriptor := range manifestList.Manifests {
+		if descriptor.Platform != nil && descriptor.Platform.OS == "linux" && descriptor.Platform.Architecture == "amd64" {
+			return &manifestList.Manifests[i]
+		}
+	}
+	return &manifestList.Manifests[0]
+}
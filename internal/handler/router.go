@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"github.com/gorilla/mux"
+)
+
+// NewRouter 创建注册了所有 API 端点的路由器，所有路由都先经过引用语法校验
+func NewRouter(h *RegistryHandler) *mux.Router {
+	r := mux.NewRouter()
+	r.Use(h.ValidationMiddleware)
+
+	// 基础 API 版本检查
+	r.HandleFunc("/v2/", h.APIVersionHandler).Methods("GET")
+
+	// 仓库列表
+	// GET /v2/_catalog
+	r.HandleFunc("/v2/_catalog", h.CatalogHandler).Methods("GET")
+
+	// Manifests 相关路由
+	// GET, PUT, HEAD, DELETE /v2/{name}/manifests/{reference}
+	r.HandleFunc("/v2/{name:.+}/manifests/{reference}", h.ManifestHandler).Methods("GET", "PUT", "HEAD", "DELETE")
+
+	// Referrers 相关路由
+	// GET /v2/{name}/referrers/{digest}
+	r.HandleFunc("/v2/{name:.+}/referrers/{digest}", h.ReferrersHandler).Methods("GET")
+
+	// Tags 相关路由
+	// GET /v2/{name}/tags/list
+	r.HandleFunc("/v2/{name:.+}/tags/list", h.TagsListHandler).Methods("GET")
+
+	// Blobs 相关路由
+	// HEAD, GET, DELETE /v2/{name}/blobs/{digest}
+	r.HandleFunc("/v2/{name:.+}/blobs/{digest}", h.BlobHandler).Methods("HEAD", "GET", "DELETE")
+
+	// POST /v2/{name}/blobs/uploads/
+	r.HandleFunc("/v2/{name:.+}/blobs/uploads/", h.InitiateBlobUploadHandler).Methods("POST")
+
+	// GET, PATCH, PUT, DELETE /v2/{name}/blobs/uploads/{uuid}
+	r.HandleFunc("/v2/{name:.+}/blobs/uploads/{uuid}", h.BlobUploadHandler).Methods("GET", "PATCH", "PUT", "DELETE")
+
+	return r
+}
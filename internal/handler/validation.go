@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"net/http"
+
+	"my_docker_registry/internal/types"
+
+	"github.com/gorilla/mux"
+)
+
+// ValidationMiddleware 在进入具体的处理函数之前，按 distribution spec 的引用语法
+// 校验路径变量和查询参数中的仓库名、tag、digest 以及上传会话 ID。
+func (h *RegistryHandler) ValidationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := validateRequest(r); err != nil {
+			statusCode := http.StatusBadRequest
+			if err.Code == types.ErrorCodeBlobUploadUnknown {
+				statusCode = http.StatusNotFound
+			}
+			types.WriteErrorResponse(w, statusCode, *err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateRequest 校验请求中的所有引用，返回遇到的第一个错误
+func validateRequest(r *http.Request) *types.RegistryError {
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+
+	checks := []struct {
+		value    string
+		validate func(string) error
+	}{
+		{vars["name"], types.ValidateRepositoryName},
+		{vars["reference"], types.ValidateReference},
+		{vars["digest"], types.ValidateDigest},
+		{vars["uuid"], types.ValidateUploadUUID},
+		{query.Get("mount"), types.ValidateDigest},
+		{query.Get("from"), types.ValidateRepositoryName},
+		{query.Get("digest"), types.ValidateDigest},
+	}
+
+	for _, check := range checks {
+		if check.value == "" {
+			continue
+		}
+		if err := check.validate(check.value); err != nil {
+			regErr := err.(types.RegistryError)
+			return &regErr
+		}
+	}
+	return nil
+}
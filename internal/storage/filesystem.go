@@ -1,12 +1,18 @@
 package storage
 
 import (
-	"encoding/json"
+	"crypto/sha256"
+	"encoding"
 	"fmt"
+	"hash"
+	"io"
 	"my_docker_registry/internal/types"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -14,16 +20,39 @@ import (
 // fileSystemDriver 实现了 StorageDriver 接口，使用本地文件系统作为后端。
 type fileSystemDriver struct {
 	rootDirectory string
+	options       FileSystemOptions
+	// uploadLocks 串行化对同一个上传会话的追加、完成和取消
+	uploadLocks keyedMutex
+	// repositoryLocks 串行化同一个仓库中 manifest、tag 以及 referrers tag 的修改
+	repositoryLocks keyedMutex
+	// gc 协调在线垃圾回收与并发的上传、挂载和 manifest 写入
+	gc gcBarrier
+}
+
+// FileSystemOptions 是 fileSystemDriver 的可选配置
+type FileSystemOptions struct {
+	// DisableFsync 关闭写入时的 fsync，只保留 rename 的原子性。
+	// 写入更快，但机器崩溃或断电后可能丢失最近的写入，仅适用于开发环境。
+	DisableFsync bool
+	// FileLocking 在进程内锁之外再使用 flock 文件锁，
+	// 使共享同一个根目录的多个 registry 进程之间也能互斥。不支持 flock 的平台上无效。
+	FileLocking bool
 }
 
 // NewFileSystemDriver 创建一个新的 fileSystemDriver 实例。
-// rootDirectory 是用于存储所有数据的根目录。
-func NewFileSystemDriver(rootDirectory string) (StorageDriver, error) {
+// rootDirectory 是用于存储所有数据的根目录。已有的旧版本数据会在创建时迁移到当前的布局。
+func NewFileSystemDriver(rootDirectory string, options FileSystemOptions) (StorageDriver, error) {
 	// 确保根目录存在
 	if err := os.MkdirAll(rootDirectory, 0755); err != nil {
 		return nil, err
 	}
-	return &fileSystemDriver{rootDirectory: rootDirectory}, nil
+	driver := &fileSystemDriver{rootDirectory: rootDirectory, options: options}
+
+	// 为旧版本写入的数据补建仓库链接和 tag 反向索引
+	if err := driver.migrate(); err != nil {
+		return nil, err
+	}
+	return driver, nil
 }
 
 // 预处理函数
@@ -35,12 +64,169 @@ func (d *fileSystemDriver) manifestPath(repoName, digest string) string {
 	return filepath.Join(d.rootDirectory, "repositories", repoName, "_manifests", "revisions", "sha256", hash)
 }
 
+// manifestMediaTypePath 构建保存 manifest 媒体类型的文件路径，与内容文件一一对应。
+// 路径格式: <root>/repositories/<name>/_manifests/mediatypes/sha256/<hash>
+func (d *fileSystemDriver) manifestMediaTypePath(repoName, digest string) string {
+	hash := strings.TrimPrefix(digest, "sha256:")
+	return filepath.Join(d.rootDirectory, "repositories", repoName, "_manifests", "mediatypes", "sha256", hash)
+}
+
+// manifestMediaType 返回 manifest 的媒体类型。
+// 优先使用 PUT 时客户端通过 Content-Type 提供并保存下来的类型，没有保存时根据内容检测。
+func (d *fileSystemDriver) manifestMediaType(repoName, digest string, content []byte) (string, error) {
+	mediaType, err := os.ReadFile(d.manifestMediaTypePath(repoName, digest))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return types.DetectManifestMediaType(content), nil
+		}
+		return "", err
+	}
+	return string(mediaType), nil
+}
+
+// referrersPath 构建保存某个 subject 的所有 referrer 的目录路径。
+// 路径格式: <root>/repositories/<name>/_manifests/referrers/sha256/<subject hash>/sha256
+func (d *fileSystemDriver) referrersPath(repoName, subject string) string {
+	hash := strings.TrimPrefix(subject, "sha256:")
+	return filepath.Join(d.rootDirectory, "repositories", repoName, "_manifests", "referrers", "sha256", hash, "sha256")
+}
+
+// repositoryPath 构建仓库根目录的路径。
+// 路径格式: <root>/repositories/<name>
+func (d *fileSystemDriver) repositoryPath(repoName string) string {
+	return filepath.Join(d.rootDirectory, "repositories", repoName)
+}
+
+// tagsPath 构建仓库所有标签所在目录的路径。
+// 路径格式: <root>/repositories/<name>/_manifests/tags
+func (d *fileSystemDriver) tagsPath(repoName string) string {
+	return filepath.Join(d.rootDirectory, "repositories", repoName, "_manifests", "tags")
+}
+
 // tagPath 构建标签链接文件的路径。
 // 路径格式: <root>/repositories/<name>/_manifests/tags/<tag>/current/link
 func (d *fileSystemDriver) tagPath(repoName, tagName string) string {
 	return filepath.Join(d.rootDirectory, "repositories", repoName, "_manifests", "tags", tagName, "current", "link")
 }
 
+// tagRefsPath 构建记录指向某个 manifest 的所有 tag 的目录路径（tag → digest 的反向索引）。
+// 目录下每个 tag 对应一个同名的空文件。
+// 路径格式: <root>/repositories/<name>/_manifests/tagrefs/sha256/<hash>
+func (d *fileSystemDriver) tagRefsPath(repoName, digest string) string {
+	hash := strings.TrimPrefix(digest, "sha256:")
+	return filepath.Join(d.rootDirectory, "repositories", repoName, "_manifests", "tagrefs", "sha256", hash)
+}
+
+// setTag 将 tag 指向 digest，并维护反向索引。tag 原先指向其他 manifest 时移除旧的反向引用。
+func (d *fileSystemDriver) setTag(repoName, tagName, digest string) error {
+	tagPath := d.tagPath(repoName, tagName)
+	if previous, err := os.ReadFile(tagPath); err == nil && string(previous) != digest {
+		if err := os.Remove(filepath.Join(d.tagRefsPath(repoName, string(previous)), tagName)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := d.writeFile(filepath.Join(d.tagRefsPath(repoName, digest), tagName), nil); err != nil {
+		return err
+	}
+	return d.writeFile(tagPath, []byte(digest))
+}
+
+// removeTag 删除 tag 及其反向引用，tag 指向的 manifest 保持不变。
+func (d *fileSystemDriver) removeTag(repoName, tagName string) error {
+	tagPath := d.tagPath(repoName, tagName)
+	digest, err := os.ReadFile(tagPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := os.RemoveAll(filepath.Join(d.tagsPath(repoName), tagName)); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(d.tagRefsPath(repoName, string(digest)), tagName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// revisionExists 检查仓库中是否存在指定 digest 的 manifest 内容文件。
+func (d *fileSystemDriver) revisionExists(repoName, digest string) (bool, error) {
+	if _, err := os.Stat(d.manifestPath(repoName, digest)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// revisionReader 返回读取仓库中 manifest 的 revisionFunc，写入时间取内容文件的修改时间
+func (d *fileSystemDriver) revisionReader(repoName string) revisionFunc {
+	return func(digest string) (string, []byte, time.Time, error) {
+		manifestPath := d.manifestPath(repoName, digest)
+		info, err := os.Stat(manifestPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return "", nil, time.Time{}, nil
+			}
+			return "", nil, time.Time{}, err
+		}
+		content, err := os.ReadFile(manifestPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return "", nil, time.Time{}, nil
+			}
+			return "", nil, time.Time{}, err
+		}
+		mediaType, err := d.manifestMediaType(repoName, digest, content)
+		if err != nil {
+			return "", nil, time.Time{}, err
+		}
+		return mediaType, content, info.ModTime(), nil
+	}
+}
+
+// referrersLister 返回枚举仓库 referrers 索引的 referrersFunc
+func (d *fileSystemDriver) referrersLister(repoName string) referrersFunc {
+	return func(subject string) ([]string, error) {
+		entries, err := os.ReadDir(d.referrersPath(repoName, subject))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		referrers := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			// 跳过原子写入过程中的临时文件
+			if !strings.HasPrefix(entry.Name(), tempFilePrefix) {
+				referrers = append(referrers, "sha256:"+entry.Name())
+			}
+		}
+		return referrers, nil
+	}
+}
+
+// tagDigests 返回仓库中所有 tag 指向的 digest，链接文件损坏的 tag 跳过
+func (d *fileSystemDriver) tagDigests(repoName string) (map[string]string, error) {
+	entries, err := os.ReadDir(d.tagsPath(repoName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	tags := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		digest, err := d.resolveReference(repoName, entry.Name())
+		if err != nil {
+			if regErr, ok := err.(types.RegistryError); ok && regErr.Code == types.ErrorCodeManifestUnknown {
+				continue
+			}
+			return nil, err
+		}
+		tags[entry.Name()] = digest
+	}
+	return tags, nil
+}
+
 // resolveReference 接受一个引用（标签或摘要）并返回摘要值。
 // 如果引用是标签，则读取链接文件来查找摘要。
 // 如果引用是摘要，则直接返回。
@@ -60,7 +246,13 @@ func (d *fileSystemDriver) resolveReference(repoName, reference string) (string,
 		}
 		return "", err // 其他类型的错误（如权限问题）。
 	}
-	return string(digestBytes), nil
+
+	// 崩溃或手工修改可能留下空的或损坏的链接文件，不能把它的内容当作摘要返回
+	digest := strings.TrimSpace(string(digestBytes))
+	if types.ValidateDigest(digest) != nil {
+		return "", types.NewError(types.ErrorCodeManifestUnknown, "manifest unknown", map[string]string{"reference": reference})
+	}
+	return digest, nil
 }
 
 // calculateDigest 计算数据的 sha256 摘要。
@@ -72,31 +264,399 @@ func calculateDigest(content []byte) string {
 // blobDataPath 根据摘要构建 blob 数据文件的路径。
 // 路径格式: <root>/blobs/sha256/<前两位哈希>/<完整哈希>
 func (d *fileSystemDriver) blobDataPath(digest string) (string, error) {
-	parts := strings.Split(digest, ":")
-	if len(parts) != 2 {
-		return "", fmt.Errorf("invalid digest format: %s", digest)
-	}
-	alg, hex := parts[0], parts[1]
-	if len(hex) < 2 {
-		return "", fmt.Errorf("invalid digest hex for path creation: %s", hex)
-	}
 	// 我们只支持 sha256
-	if alg != "sha256" {
-		return "", fmt.Errorf("unsupported digest algorithm: %s", alg)
+	if err := types.ValidateDigest(digest); err != nil {
+		return "", err
 	}
+	alg, hex, _ := strings.Cut(digest, ":")
 
 	return filepath.Join(d.rootDirectory, "blobs", alg, hex[:2], hex), nil
 }
 
+// layerLinkPath 构建仓库对 blob 的链接文件路径，只有链接到仓库的 blob 才能通过该仓库访问。
+// 路径格式: <root>/repositories/<name>/_layers/sha256/<hash>/link
+func (d *fileSystemDriver) layerLinkPath(repoName, digest string) string {
+	hash := strings.TrimPrefix(digest, "sha256:")
+	return filepath.Join(d.rootDirectory, "repositories", repoName, "_layers", "sha256", hash, "link")
+}
+
+// linkBlob 将 blob 链接到仓库。
+func (d *fileSystemDriver) linkBlob(repoName, digest string) error {
+	return d.writeFile(d.layerLinkPath(repoName, digest), []byte(digest))
+}
+
+// statLinkedBlob 检查 blob 是否已链接到仓库并且数据存在，返回数据文件路径和文件信息。
+// 未链接到仓库的 blob 即使存在于全局存储中也返回 BLOB_UNKNOWN。
+func (d *fileSystemDriver) statLinkedBlob(repoName, digest string) (string, os.FileInfo, error) {
+	// 1. 校验仓库名并获取 blob 文件的标准路径
+	if err := types.ValidateRepositoryName(repoName); err != nil {
+		return "", nil, err
+	}
+	path, err := d.blobDataPath(digest)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// 2. 检查 blob 是否链接到了该仓库
+	if _, err := os.Stat(d.layerLinkPath(repoName, digest)); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil, types.NewError(types.ErrorCodeBlobUnknown, "blob unknown", map[string]string{"digest": digest})
+		}
+		return "", nil, err
+	}
+
+	// 3. 使用 os.Stat 检查文件是否存在并获取信息
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// 文件不存在，返回标准的 blob unknown 错误
+			return "", nil, types.NewError(types.ErrorCodeBlobUnknown, "blob unknown", map[string]string{"digest": digest})
+		}
+		// 其他文件系统错误
+		return "", nil, err
+	}
+
+	return path, info, nil
+}
+
 // blobUploadPath 根据上传 ID 构建临时上传目录的路径。
 // 路径格式: <root>/repositories/<name>/_uploads/<uuid>
 func (d *fileSystemDriver) blobUploadPath(repoName, uuid string) string {
 	return filepath.Join(d.rootDirectory, "repositories", repoName, "_uploads", uuid)
 }
 
+// repositoryLockPath 构建仓库文件锁的路径，仓库名经过哈希以免与嵌套的仓库目录冲突。
+// 路径格式: <root>/_locks/repositories/<sha256(name)>
+func (d *fileSystemDriver) repositoryLockPath(repoName string) string {
+	hash := strings.TrimPrefix(calculateDigest([]byte(repoName)), "sha256:")
+	return filepath.Join(d.rootDirectory, "_locks", "repositories", hash)
+}
+
+// lock 获取 locks 中 key 对应的进程内锁；启用文件锁时再获取 lockPath 上的 flock。
+// 返回用于释放锁的函数。
+func (d *fileSystemDriver) lock(locks *keyedMutex, key, lockPath string) (func(), error) {
+	unlock := locks.Lock(key)
+	if !d.options.FileLocking {
+		return unlock, nil
+	}
+
+	unlockFile, err := lockFile(lockPath)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return func() {
+		unlockFile()
+		unlock()
+	}, nil
+}
+
+// lockRepository 获取仓库锁，修改 tag 和 manifest 前必须持有
+func (d *fileSystemDriver) lockRepository(repoName string) (func(), error) {
+	if d.options.FileLocking {
+		if err := d.mkdirAll(filepath.Dir(d.repositoryLockPath(repoName))); err != nil {
+			return nil, err
+		}
+	}
+	return d.lock(&d.repositoryLocks, repoName, d.repositoryLockPath(repoName))
+}
+
+// lockUpload 获取上传会话锁，读取偏移量、追加数据以及完成或取消上传前必须持有。
+// 文件锁放在会话目录中，会话已不存在时返回 BLOB_UPLOAD_UNKNOWN。
+func (d *fileSystemDriver) lockUpload(repoName, uuid string) (func(), error) {
+	lockPath := filepath.Join(d.blobUploadPath(repoName, uuid), "lock")
+	unlock, err := d.lock(&d.uploadLocks, repoName+"@"+uuid, lockPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, types.NewError(types.ErrorCodeBlobUploadUnknown, "blob upload unknown", map[string]string{"uuid": uuid})
+		}
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// uploadStartedAtPath 构建记录上传会话创建时间的文件路径，用于清理过期的会话。
+// 路径格式: <root>/repositories/<name>/_uploads/<uuid>/startedat
+func (d *fileSystemDriver) uploadStartedAtPath(repoName, uuid string) string {
+	return filepath.Join(d.blobUploadPath(repoName, uuid), "startedat")
+}
+
+// firstError 返回第一个非 nil 的错误。
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tempFilePrefix 是原子写入时临时文件名的前缀，遍历目录时应跳过这类文件
+const tempFilePrefix = ".tmp-"
+
+// writeFile 原子地写入文件，必要时创建父目录。
+// 数据先写入同目录下的临时文件并 fsync，再 rename 覆盖目标文件，最后 fsync 父目录，
+// 因此崩溃后目标文件要么是旧内容，要么是完整的新内容，不会出现截断或空文件。
+func (d *fileSystemDriver) writeFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := d.mkdirAll(dir); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, tempFilePrefix+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := firstError(d.syncFile(tmp), tmp.Chmod(0644), tmp.Close()); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return d.rename(tmpPath, path)
+}
+
+// rename 将 oldPath 重命名为 newPath，并 fsync 新路径所在目录使目录项持久化
+func (d *fileSystemDriver) rename(oldPath, newPath string) error {
+	if err := os.Rename(oldPath, newPath); err != nil {
+		os.Remove(oldPath)
+		return err
+	}
+	return d.syncDir(filepath.Dir(newPath))
+}
+
+// syncUploadData 将上传会话的数据文件刷到磁盘，关闭 fsync 时什么也不做
+func (d *fileSystemDriver) syncUploadData(dataPath string) error {
+	if d.options.DisableFsync {
+		return nil
+	}
+	file, err := os.OpenFile(dataPath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// mkdirAll 创建目录及其所有父目录，并 fsync 每个新建目录的父目录，
+// 否则崩溃后新目录（以及其中已 fsync 的文件）可能整个消失。
+func (d *fileSystemDriver) mkdirAll(dir string) error {
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		return nil
+	}
+
+	parent := filepath.Dir(dir)
+	if parent != dir {
+		if err := d.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return d.syncDir(parent)
+}
+
+// syncFile 将文件内容刷到磁盘，关闭 fsync 时什么也不做
+func (d *fileSystemDriver) syncFile(file *os.File) error {
+	if d.options.DisableFsync {
+		return nil
+	}
+	return file.Sync()
+}
+
+// syncDir 将目录项（新建、重命名的文件）刷到磁盘，关闭 fsync 时什么也不做
+func (d *fileSystemDriver) syncDir(dir string) error {
+	if d.options.DisableFsync {
+		return nil
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// writeRevision 存储 manifest 内容文件及其媒体类型。
+func (d *fileSystemDriver) writeRevision(repoName, digest, mediaType string, content []byte) error {
+	if err := d.writeFile(d.manifestPath(repoName, digest), content); err != nil {
+		return err
+	}
+	return d.writeFile(d.manifestMediaTypePath(repoName, digest), []byte(mediaType))
+}
+
+// updateReferrersTag 根据 referrers 索引目录重新生成 subject 的 referrers tag。
+// 调用方需持有仓库锁，因此并发推送的多个 referrer 最终都会出现在 tag 指向的 index 中。
+func (d *fileSystemDriver) updateReferrersTag(repoName, subject string) error {
+	index, err := d.GetReferrers(types.GetReferrersParams{RepositoryName: repoName, Digest: subject})
+	if err != nil {
+		return err
+	}
+	writeIndex := func(digest string, content []byte) error {
+		return d.writeRevision(repoName, digest, types.OCIIndexMediaType, content)
+	}
+	setTag := func(tagName, digest string) error {
+		return d.setTag(repoName, tagName, digest)
+	}
+	removeTag := func(tagName string) error {
+		return d.removeTag(repoName, tagName)
+	}
+	return syncReferrersTag(subject, index, writeIndex, setTag, removeTag)
+}
+
+// hashStatePath 构建上传会话在指定偏移量处的 sha256 哈希状态文件路径。
+// 路径格式: <root>/repositories/<name>/_uploads/<uuid>/hashstates/sha256/<offset>
+func (d *fileSystemDriver) hashStatePath(repoName, uuid string, offset int64) string {
+	return filepath.Join(d.blobUploadPath(repoName, uuid), "hashstates", "sha256", strconv.FormatInt(offset, 10))
+}
+
+// resumeHash 恢复上传会话在 offset 处的 sha256 哈希状态。
+// 优先读取已保存的哈希状态；状态文件缺失时（如旧版本创建的会话）退回到重新读取前 offset 字节计算。
+func (d *fileSystemDriver) resumeHash(repoName, uuid string, offset int64) (hash.Hash, error) {
+	hasher := sha256.New()
+	if offset == 0 {
+		return hasher, nil
+	}
+
+	state, err := os.ReadFile(d.hashStatePath(repoName, uuid, offset))
+	if err == nil {
+		if err := hasher.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err == nil {
+			return hasher, nil
+		}
+		hasher.Reset()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	file, err := os.Open(filepath.Join(d.blobUploadPath(repoName, uuid), "data"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := io.CopyN(hasher, file, offset); err != nil {
+		return nil, err
+	}
+	return hasher, nil
+}
+
+// saveHashState 保存上传会话在 offset 处的 sha256 哈希状态，并删除上一个偏移量的旧状态。
+func (d *fileSystemDriver) saveHashState(repoName, uuid string, previousOffset, offset int64, hasher hash.Hash) error {
+	state, err := hasher.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	if err := d.writeFile(d.hashStatePath(repoName, uuid, offset), state); err != nil {
+		return err
+	}
+
+	if previousOffset != offset {
+		os.Remove(d.hashStatePath(repoName, uuid, previousOffset))
+	}
+	return nil
+}
+
+// committedUploadOffset 返回上传会话已提交的偏移量，即已保存的哈希状态中最大的偏移量。
+// 追加数据在保存新的哈希状态之后才不会被回滚，因此不需要持有会话锁，也不会等待进行中的追加。
+// 旧版本创建的会话没有哈希状态，退回到数据文件的大小。
+func (d *fileSystemDriver) committedUploadOffset(repoName, uuid string) (int64, error) {
+	entries, err := os.ReadDir(filepath.Dir(d.hashStatePath(repoName, uuid, 0)))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	offset := int64(-1)
+	for _, entry := range entries {
+		if n, err := strconv.ParseInt(entry.Name(), 10, 64); err == nil && n > offset {
+			offset = n
+		}
+	}
+	if offset >= 0 {
+		return offset, nil
+	}
+
+	info, err := os.Stat(filepath.Join(d.blobUploadPath(repoName, uuid), "data"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// appendUploadData 将 body 追加到上传会话数据文件的末尾，同时增量计算并保存哈希状态。
+// hasRange 为 true 时校验 Content-Range 的起始位置与长度，不符时回滚本次写入并返回 RANGE_INVALID。
+// 返回追加后的数据总大小以及对应的哈希状态。
+func (d *fileSystemDriver) appendUploadData(repoName, uuid string, body io.Reader, hasRange bool, rangeFrom, rangeTo int64) (int64, hash.Hash, error) {
+	// 1. 获取当前文件大小以校验 Range
+	dataPath := filepath.Join(d.blobUploadPath(repoName, uuid), "data")
+	var currentSize int64
+	if info, err := os.Stat(dataPath); err == nil {
+		currentSize = info.Size()
+	} else if !os.IsNotExist(err) {
+		return 0, nil, err
+	}
+
+	// 2. 校验 Range，流式上传（没有 Content-Range）直接追加到当前偏移量
+	if hasRange && rangeFrom != currentSize {
+		return 0, nil, types.NewError(types.ErrorCodeRangeInvalid, "invalid range", fmt.Sprintf("expected range start %d, got %d", currentSize, rangeFrom))
+	}
+
+	// 3. 恢复当前偏移量处的哈希状态
+	hasher, err := d.resumeHash(repoName, uuid, currentSize)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// 4. 打开文件并将请求体流式追加到末尾，同时增量计算哈希
+	file, err := os.OpenFile(dataPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+
+	var bytesWritten int64
+	if body != nil {
+		bytesWritten, err = io.Copy(io.MultiWriter(file, hasher), body)
+		if err != nil {
+			file.Truncate(currentSize)
+			return 0, nil, err
+		}
+	}
+
+	// 写入的长度与 Content-Range 不符时回滚本次写入
+	if hasRange && rangeTo-rangeFrom+1 != bytesWritten {
+		file.Truncate(currentSize)
+		return 0, nil, types.NewError(types.ErrorCodeRangeInvalid, "invalid range", fmt.Sprintf("range length %d does not match content length %d", rangeTo-rangeFrom+1, bytesWritten))
+	}
+
+	// 5. 保存新偏移量处的哈希状态，完成上传时即可直接得到摘要
+	newOffset := currentSize + bytesWritten
+	if err := d.saveHashState(repoName, uuid, currentSize, newOffset, hasher); err != nil {
+		file.Truncate(currentSize)
+		return 0, nil, err
+	}
+
+	return newOffset, hasher, nil
+}
+
 // --- Manifest API ---
 
 func (d *fileSystemDriver) GetManifest(params types.GetManifestParams) (*types.ManifestResponse, error) {
+	// 校验引用语法
+	if err := firstError(types.ValidateRepositoryName(params.RepositoryName), types.ValidateReference(params.Reference)); err != nil {
+		return nil, err
+	}
+
 	// 1. 将 tag 处理为 digest
 	digest, err := d.resolveReference(params.RepositoryName, params.Reference)
 	if err != nil {
@@ -113,91 +673,123 @@ func (d *fileSystemDriver) GetManifest(params types.GetManifestParams) (*types.M
 		return nil, err
 	}
 
-	// 3. 检测媒体类型
-	mediaType := types.DetectManifestMediaType(content)
-
-	// 4. 创建响应对象
-	response := &types.ManifestResponse{
-		Content:   content,
-		MediaType: mediaType,
-	}
-
-	// 5. 根据类型解析内容
-	switch mediaType {
-	case types.ManifestV2MediaType:
-		var manifest types.Manifest
-		if err := json.Unmarshal(content, &manifest); err != nil {
-			return nil, types.NewError(types.ErrorCodeManifestInvalid, "failed to parse manifest", err.Error())
-		}
-		response.Manifest = &manifest
-	case types.ManifestListV2MediaType:
-		var manifestList types.ManifestList
-		if err := json.Unmarshal(content, &manifestList); err != nil {
-			return nil, types.NewError(types.ErrorCodeManifestInvalid, "failed to parse manifest list", err.Error())
-		}
-		response.ManifestList = &manifestList
+	// 3. 获取保存的媒体类型
+	mediaType, err := d.manifestMediaType(params.RepositoryName, digest, content)
+	if err != nil {
+		return nil, err
 	}
 
-	return response, nil
+	// 4. 创建响应对象
+	return newManifestResponse(mediaType, content)
 }
 
 func (d *fileSystemDriver) PutManifest(params types.PutManifestParams) (*types.ManifestData, error) {
-	// 1. 计算上传内容的 digest。
-	digest := calculateDigest(params.Content)
+	// 校验引用语法
+	if err := firstError(types.ValidateRepositoryName(params.RepositoryName), types.ValidateReference(params.Reference)); err != nil {
+		return nil, err
+	}
+
+	// 1. 读取 manifest 内容并计算 digest。
+	content, err := types.ReadManifestContent(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	digest := calculateDigest(content)
 
 	// 2. 检测 digest 是否匹配
 	if strings.HasPrefix(params.Reference, "sha256:") && params.Reference != digest {
 		return nil, types.NewError(types.ErrorCodeDigestInvalid, "digest mismatch", nil)
 	}
 
-	// 3. 校验 manifest 引用的所有 blob 是否都存在。
-	var manifest types.Manifest
-	if err := json.Unmarshal(params.Content, &manifest); err != nil {
-		return nil, types.NewError(types.ErrorCodeManifestInvalid, "failed to parse manifest", err.Error())
+	// 3. 确定媒体类型，客户端提供的 Content-Type 是权威的
+	mediaType, validationType, err := resolveManifestMediaType(params.MediaType, content)
+	if err != nil {
+		return nil, err
 	}
 
-	// 检查 config blob
-	blobParams := types.GetBlobParams{RepositoryName: params.RepositoryName, Digest: manifest.Config.Digest}
-	if _, err := d.BlobExists(blobParams); err != nil {
-		return nil, types.NewError(types.ErrorCodeBlobUnknown, "config blob unknown", map[string]string{"digest": manifest.Config.Digest})
+	// subject 的 digest 用于构造 referrers 索引，必须在任何写入之前校验
+	subject, err := parseManifestSubject(content)
+	if err != nil {
+		return nil, err
 	}
-	// 检查所有 layer blobs
-	for _, layer := range manifest.Layers {
-		blobParams.Digest = layer.Digest
-		if _, err := d.BlobExists(blobParams); err != nil {
-			return nil, types.NewError(types.ErrorCodeBlobUnknown, "layer blob unknown", map[string]string{"digest": layer.Digest})
-		}
+
+	// 写入期间不允许垃圾回收进入清除阶段，manifest 引用的 blob 会被记录下来，
+	// 即使它们在本轮标记中未被标记也不会被清除。写屏障必须在仓库锁之前获取，与垃圾回收的加锁顺序一致
+	_, blobs := manifestReferences(validationType, content)
+	endWrite := d.gc.beginWrite(blobs...)
+	defer endWrite()
+
+	// 持有仓库锁，引用校验与写入之间不会插入并发的删除，tag 与 referrers tag 的修改也不会交错
+	unlock, err := d.lockRepository(params.RepositoryName)
+	if err != nil {
+		return nil, err
 	}
+	defer unlock()
 
-	// 4. 存储 manifest 内容文件。
-	manifestPath := d.manifestPath(params.RepositoryName, digest)
-	if err := os.MkdirAll(filepath.Dir(manifestPath), 0755); err != nil {
+	// 4. 根据媒体类型校验 manifest 引用的内容是否都存在：
+	// manifest list / OCI index 校验子 manifest，镜像清单校验 config 和 layers。
+	manifestExists := func(digest string) (bool, error) {
+		return d.revisionExists(params.RepositoryName, digest)
+	}
+	blobExists := func(digest string) (bool, error) {
+		_, err := d.BlobExists(types.GetBlobParams{RepositoryName: params.RepositoryName, Digest: digest})
+		if regErr, ok := err.(types.RegistryError); ok {
+			switch regErr.Code {
+			case types.ErrorCodeBlobUnknown:
+				return false, nil
+			case types.ErrorCodeDigestInvalid:
+				return false, types.NewManifestInvalidError(fmt.Sprintf("invalid digest: %s", digest))
+			}
+		}
+		return err == nil, err
+	}
+	if err := validateManifestReferences(validationType, content, manifestExists, blobExists); err != nil {
 		return nil, err
 	}
-	if err := os.WriteFile(manifestPath, params.Content, 0644); err != nil {
+
+	// 5. 存储 manifest 内容文件及其媒体类型。
+	if err := d.writeRevision(params.RepositoryName, digest, mediaType, content); err != nil {
 		return nil, err
 	}
 
-	// 5. 如果 reference 是 tag，则创建 tag 链接文件。
-	if !strings.HasPrefix(params.Reference, "sha256:") {
-		tagPath := d.tagPath(params.RepositoryName, params.Reference)
-		if err := os.MkdirAll(filepath.Dir(tagPath), 0755); err != nil {
+	// 6. 如果 manifest 带有 subject，将其记录到 subject 的 referrers 索引中，
+	// 并更新供不支持 referrers API 的客户端使用的 sha256-<hex> tag。
+	// subject 不要求已经存在，允许先推送签名再推送被签名的镜像
+	if subject != nil {
+		referrerPath := filepath.Join(d.referrersPath(params.RepositoryName, subject.Digest), strings.TrimPrefix(digest, "sha256:"))
+		if err := d.writeFile(referrerPath, []byte(digest)); err != nil {
+			return nil, err
+		}
+		if err := d.updateReferrersTag(params.RepositoryName, subject.Digest); err != nil {
 			return nil, err
 		}
-		if err := os.WriteFile(tagPath, []byte(digest), 0644); err != nil {
+	}
+
+	// 7. 如果 reference 是 tag，则创建 tag 链接文件。
+	if !strings.HasPrefix(params.Reference, "sha256:") {
+		if err := d.setTag(params.RepositoryName, params.Reference, digest); err != nil {
 			return nil, err
 		}
 	}
 
-	// 6. 返回成功结果。
+	// 8. 返回成功结果。
 	result := &types.ManifestData{
-		Digest:   digest,
-		Location: fmt.Sprintf("/v2/%s/manifests/%s", params.RepositoryName, digest),
+		Digest:    digest,
+		Location:  fmt.Sprintf("/v2/%s/manifests/%s", params.RepositoryName, digest),
+		MediaType: mediaType,
+	}
+	if subject != nil {
+		result.Subject = subject.Digest
 	}
 	return result, nil
 }
 
 func (d *fileSystemDriver) ManifestExists(params types.GetManifestParams) (*types.ManifestData, error) {
+	// 校验引用语法
+	if err := firstError(types.ValidateRepositoryName(params.RepositoryName), types.ValidateReference(params.Reference)); err != nil {
+		return nil, err
+	}
+
 	// 1. 解析引用，获取 digest
 	digest, err := d.resolveReference(params.RepositoryName, params.Reference)
 	if err != nil {
@@ -217,8 +809,11 @@ func (d *fileSystemDriver) ManifestExists(params types.GetManifestParams) (*type
 		return nil, err // 其他文件系统错误
 	}
 
-	// 4. 检测媒体类型
-	mediaType := types.DetectManifestMediaType(content)
+	// 4. 获取保存的媒体类型
+	mediaType, err := d.manifestMediaType(params.RepositoryName, digest, content)
+	if err != nil {
+		return nil, err
+	}
 
 	// 5. 构建并返回 ManifestData
 	manifestData := &types.ManifestData{
@@ -226,70 +821,312 @@ func (d *fileSystemDriver) ManifestExists(params types.GetManifestParams) (*type
 		// Location 通常由 handler 构建，但在这里返回也很方便
 		Location:      fmt.Sprintf("/v2/%s/manifests/%s", params.RepositoryName, digest),
 		ContentLength: len(content), // 内容长度
-		MediaType:     mediaType,    // 检测到的媒体类型
+		MediaType:     mediaType,    // 保存的或检测到的媒体类型
 	}
 
 	return manifestData, nil
 }
 
 func (d *fileSystemDriver) DeleteManifest(params types.GetManifestParams) error {
+	// 校验引用语法
+	if err := firstError(types.ValidateRepositoryName(params.RepositoryName), types.ValidateReference(params.Reference)); err != nil {
+		return err
+	}
+
+	// 持有仓库锁，避免与并发的 PUT 交错修改 tag 和 referrers 索引
+	unlock, err := d.lockRepository(params.RepositoryName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
+	// 1. 解析引用，引用不存在时返回 MANIFEST_UNKNOWN
 	digest, err := d.resolveReference(params.RepositoryName, params.Reference)
 	if err != nil {
 		return err
 	}
 
-	manifestPath := d.manifestPath(params.RepositoryName, digest)
-	// 根据 digest 移除文件
-	err = os.Remove(manifestPath)
+	// 2. 按 tag 删除只是取消标记：移除该 tag，manifest 以及指向它的其他 tag 保持不变
+	if !strings.HasPrefix(params.Reference, "sha256:") {
+		return d.removeTag(params.RepositoryName, params.Reference)
+	}
+
+	// 3. 按 digest 删除时移除 manifest 本身以及指向它的 tag
+	subject, err := d.deleteRevision(params.RepositoryName, digest)
+	if err != nil {
+		return err
+	}
+
+	// 4. 如果 manifest 是某个 subject 的 referrer，更新 sha256-<hex> tag
+	if subject != "" {
+		return d.updateReferrersTag(params.RepositoryName, subject)
+	}
+	return nil
+}
+
+// deleteRevision 删除 manifest 及其媒体类型、所有指向它的 tag，并将其从 subject 的 referrers 索引中移除。
+// 返回 manifest 的 subject（没有时为空），由调用方负责更新对应的 referrers tag。调用方需持有仓库锁。
+func (d *fileSystemDriver) deleteRevision(repoName, digest string) (string, error) {
+	// 1. 先读取内容以便清理 referrers 索引
+	manifestPath := d.manifestPath(repoName, digest)
+	content, err := os.ReadFile(manifestPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return types.NewError(types.ErrorCodeManifestUnknown, "manifest unknown", map[string]string{"digest": digest})
+			return "", types.NewError(types.ErrorCodeManifestUnknown, "manifest unknown", map[string]string{"digest": digest})
 		}
-		return err
+		return "", err
 	}
 
-	// 如果原始引用是 tag，则删除 tag 链接文件
-	if !strings.HasPrefix(params.Reference, "sha256:") {
-		tagPath := d.tagPath(params.RepositoryName, params.Reference)
-		err = os.Remove(tagPath)
-		if err != nil && !os.IsNotExist(err) {
+	// 2. 通过反向索引移除所有指向该 manifest 的 tag
+	tagRefsPath := d.tagRefsPath(repoName, digest)
+	tagRefs, err := os.ReadDir(tagRefsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	for _, tagRef := range tagRefs {
+		// 只删除仍然指向该 digest 的 tag
+		tagName := tagRef.Name()
+		if current, err := os.ReadFile(d.tagPath(repoName, tagName)); err == nil && string(current) == digest {
+			if err := d.removeTag(repoName, tagName); err != nil {
+				return "", err
+			}
+		}
+	}
+	if err := os.RemoveAll(tagRefsPath); err != nil {
+		return "", err
+	}
+
+	// 3. 根据 digest 移除文件以及保存的媒体类型
+	if err := os.Remove(manifestPath); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if err := os.Remove(d.manifestMediaTypePath(repoName, digest)); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	// 4. 从 subject 的 referrers 索引中移除
+	subject := manifestSubject(content)
+	if subject == nil {
+		return "", nil
+	}
+	referrerPath := filepath.Join(d.referrersPath(repoName, subject.Digest), strings.TrimPrefix(digest, "sha256:"))
+	if err := os.Remove(referrerPath); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return subject.Digest, nil
+}
+
+func (d *fileSystemDriver) ListTags(params types.ListTagsParams) (*types.TagsList, error) {
+	// 校验引用语法
+	if err := types.ValidateRepositoryName(params.RepositoryName); err != nil {
+		return nil, err
+	}
+
+	// 1. 检查仓库是否存在
+	if _, err := os.Stat(d.repositoryPath(params.RepositoryName)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, types.NewNameUnknownError(params.RepositoryName)
+		}
+		return nil, err
+	}
+
+	// 2. 枚举 _manifests/tags 下的所有标签目录
+	entries, err := os.ReadDir(d.tagsPath(params.RepositoryName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	tags := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		// 只有链接文件仍然存在的标签才算有效（删除 tag 时只会移除链接文件）
+		if _, err := os.Stat(d.tagPath(params.RepositoryName, entry.Name())); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		tags = append(tags, entry.Name())
+	}
+	sort.Strings(tags)
+
+	// 3. 按 n 和 last 分页
+	page, hasMore := types.Paginate(tags, params.N, params.Last)
+	return &types.TagsList{
+		Name:    params.RepositoryName,
+		Tags:    page,
+		HasMore: hasMore,
+	}, nil
+}
+
+func (d *fileSystemDriver) GetReferrers(params types.GetReferrersParams) (*types.ManifestList, error) {
+	// 1. 校验引用语法并检查仓库是否存在
+	if err := firstError(types.ValidateRepositoryName(params.RepositoryName), types.ValidateDigest(params.Digest)); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(d.repositoryPath(params.RepositoryName)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, types.NewNameUnknownError(params.RepositoryName)
+		}
+		return nil, err
+	}
+
+	// 2. 枚举 subject 的 referrers 索引并读取每个 referrer 构建描述符，subject 不存在时返回空列表
+	referrers, err := d.referrersLister(params.RepositoryName)(params.Digest)
+	if err != nil {
+		return nil, err
+	}
+	return referrersIndex(referrers, d.revisionReader(params.RepositoryName))
+}
+
+// --- Catalog API ---
+
+func (d *fileSystemDriver) ListRepositories(params types.ListRepositoriesParams) (*types.Catalog, error) {
+	// 1. 遍历 repositories 目录，包含 _manifests 或 _layers 的目录即为一个仓库
+	// 仓库名可以是多级的（如 org/team/app），因此需要递归查找
+	root := filepath.Join(d.rootDirectory, "repositories")
+	var repositories []string
+	err := filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
 			return err
 		}
+		if !entry.IsDir() || path == root {
+			return nil
+		}
+
+		// _manifests、_uploads 等是仓库的内部目录，不再向下查找
+		if strings.HasPrefix(entry.Name(), "_") {
+			if entry.Name() == "_manifests" || entry.Name() == "_layers" {
+				repoName, err := filepath.Rel(root, filepath.Dir(path))
+				if err != nil {
+					return err
+				}
+				// 同一目录下的条目按字典序遍历，_layers 和 _manifests 会相邻出现
+				repoName = filepath.ToSlash(repoName)
+				if n := len(repositories); n == 0 || repositories[n-1] != repoName {
+					repositories = append(repositories, repoName)
+				}
+			}
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	// 2. 按字典序排序并分页
+	sort.Strings(repositories)
+	page, hasMore := types.Paginate(repositories, params.N, params.Last)
+	if page == nil {
+		page = []string{}
+	}
+	return &types.Catalog{
+		Repositories: page,
+		HasMore:      hasMore,
+	}, nil
 }
 
 // --- Blob API ---
 
 func (d *fileSystemDriver) InitiateBlobUpload(params types.InitiateBlobUploadParams) (*types.InitiateBlobUploadResponse, error) {
+	// 校验引用语法
+	if err := types.ValidateRepositoryName(params.RepositoryName); err != nil {
+		return nil, err
+	}
+
 	// 检查是否是跨仓库挂载请求
-	if params.Mount != "" && params.From != "" {
-		// 检查源 blob 是否存在
-		blobParams := types.GetBlobParams{RepositoryName: params.From, Digest: params.Mount}
-		status, err := d.BlobExists(blobParams)
-		if err != nil {
-			// 如果源 blob 不存在，则退回到普通上传流程
-			return d.initiateRegularUpload(params.RepositoryName)
+	if params.Mount != "" {
+		mountedStatus, err := d.mountBlob(params.RepositoryName, params.Mount, params.From)
+		if err == nil {
+			return &types.InitiateBlobUploadResponse{
+				Status:        mountedStatus,
+				MountedStatus: mountedStatus,
+			}, nil
 		}
-
-		// 源 blob 存在，创建 201 Mounted 状态
-		mountedStatus := &types.BlobUploadMountedStatus{
-			Location:      fmt.Sprintf("/v2/%s/blobs/%s", params.RepositoryName, status.Digest),
-			ContentLength: status.ContentLength,
-			Digest:        status.Digest,
+		if regErr, ok := err.(types.RegistryError); !ok || (regErr.Code != types.ErrorCodeBlobUnknown && regErr.Code != types.ErrorCodeDigestInvalid) {
+			return nil, err
 		}
-		return &types.InitiateBlobUploadResponse{
-			Status:        mountedStatus,
-			MountedStatus: mountedStatus,
-		}, nil
+		// 如果无法挂载，则退回到上传流程
+	}
+
+	// 单请求上传流程：带有 digest 时请求体即为完整的 blob
+	if params.Digest != "" {
+		return d.initiateMonolithicUpload(params)
 	}
 
 	// 普通上传流程
 	return d.initiateRegularUpload(params.RepositoryName)
 }
 
+// mountBlob 将 blob 从 from 仓库挂载到目标仓库，即在目标仓库中创建指向同一份数据的链接。
+// blob 必须已链接到 from 仓库。按 OCI 规范 from 可以省略，此时 registry 可以挂载调用者有权读取的 blob，
+// 本 registry 没有鉴权，因此只接受已经链接到目标仓库的 blob，省略 from 实际上不会挂载任何新的 blob。
+func (d *fileSystemDriver) mountBlob(repoName, digest, from string) (*types.BlobUploadMountedStatus, error) {
+	// 校验和链接之间不允许垃圾回收清除该 blob
+	endWrite := d.gc.beginWrite(digest)
+	defer endWrite()
+
+	// 1. 校验源 blob。未指定来源仓库时只接受已经链接到目标仓库的 blob，
+	// 否则任何人都可以通过猜测 digest 读取其他仓库的内容；无法挂载时调用方退回到上传流程
+	source := from
+	if source == "" {
+		source = repoName
+	}
+	_, info, err := d.statLinkedBlob(source, digest)
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+
+	// 2. 在目标仓库中创建链接
+	if err := d.linkBlob(repoName, digest); err != nil {
+		return nil, err
+	}
+
+	// 3. 创建 201 Mounted 状态
+	return &types.BlobUploadMountedStatus{
+		Location:      fmt.Sprintf("/v2/%s/blobs/%s", repoName, digest),
+		ContentLength: int(size),
+		Digest:        digest,
+	}, nil
+}
+
+// initiateMonolithicUpload 处理 POST ?digest= 的单请求上传，
+// 复用上传会话和 CompleteBlobUpload 的校验与存储流程
+func (d *fileSystemDriver) initiateMonolithicUpload(params types.InitiateBlobUploadParams) (*types.InitiateBlobUploadResponse, error) {
+	// 1. 创建一个内部使用的上传会话
+	initiated, err := d.initiateRegularUpload(params.RepositoryName)
+	if err != nil {
+		return nil, err
+	}
+	uuidStr := initiated.InitiatedStatus.UUID
+
+	// 2. 写入完整内容并校验摘要
+	completed, err := d.CompleteBlobUpload(types.CompleteBlobUploadParams{
+		RepositoryName: params.RepositoryName,
+		UUID:           uuidStr,
+		Digest:         params.Digest,
+		Body:           params.Body,
+	})
+	if err != nil {
+		// 失败时清理上传会话，客户端不会知道这个会话的存在
+		os.RemoveAll(d.blobUploadPath(params.RepositoryName, uuidStr))
+		return nil, err
+	}
+
+	// 3. 创建 201 Created 状态
+	return &types.InitiateBlobUploadResponse{
+		Status:          completed,
+		CompletedStatus: completed,
+	}, nil
+}
+
 // initiateRegularUpload 处理标准的 blob 上传初始化
 func (d *fileSystemDriver) initiateRegularUpload(repoName string) (*types.InitiateBlobUploadResponse, error) {
 	// 1. 生成一个新的 UUID 作为上传会话 ID
@@ -299,11 +1136,21 @@ func (d *fileSystemDriver) initiateRegularUpload(repoName string) (*types.Initia
 	}
 	uuidStr := u.String()
 
-	// 2. 创建临时上传目录
+	// 2. 创建临时上传目录，并记录创建时间供过期清理使用
 	uploadPath := d.blobUploadPath(repoName, uuidStr)
 	if err := os.MkdirAll(uploadPath, 0755); err != nil {
 		return nil, err
 	}
+	startedAt := time.Now().UTC().Format(time.RFC3339)
+	if err := d.writeFile(d.uploadStartedAtPath(repoName, uuidStr), []byte(startedAt)); err != nil {
+		os.RemoveAll(uploadPath)
+		return nil, err
+	}
+	// 保存偏移量 0 处的哈希状态，已提交的偏移量总能从哈希状态中得到
+	if err := d.saveHashState(repoName, uuidStr, 0, 0, sha256.New()); err != nil {
+		os.RemoveAll(uploadPath)
+		return nil, err
+	}
 
 	// 3. 创建 202 Accepted 状态
 	initiatedStatus := &types.BlobUploadInitiatedStatus{
@@ -318,24 +1165,13 @@ func (d *fileSystemDriver) initiateRegularUpload(repoName string) (*types.Initia
 }
 
 func (d *fileSystemDriver) BlobExists(params types.GetBlobParams) (*types.BlobStatus, error) {
-	// 1. 获取 blob 文件的标准路径
-	path, err := d.blobDataPath(params.Digest)
-	if err != nil {
-		return nil, types.NewError(types.ErrorCodeDigestInvalid, "invalid digest", err.Error())
-	}
-
-	// 2. 使用 os.Stat 检查文件是否存在并获取信息
-	info, err := os.Stat(path)
+	// 1. 检查 blob 是否链接到仓库并获取文件信息
+	_, info, err := d.statLinkedBlob(params.RepositoryName, params.Digest)
 	if err != nil {
-		if os.IsNotExist(err) {
-			// 文件不存在，返回标准的 blob unknown 错误
-			return nil, types.NewError(types.ErrorCodeBlobUnknown, "blob unknown", map[string]string{"digest": params.Digest})
-		}
-		// 其他文件系统错误
 		return nil, err
 	}
 
-	// 3. 构建并返回 BlobStatus
+	// 2. 构建并返回 BlobStatus
 	status := &types.BlobStatus{
 		Digest:        params.Digest,
 		ContentLength: int(info.Size()),
@@ -345,30 +1181,19 @@ func (d *fileSystemDriver) BlobExists(params types.GetBlobParams) (*types.BlobSt
 }
 
 func (d *fileSystemDriver) RetrieveBlob(params types.GetBlobParams) (*types.BlobStatus, error) {
-	// 1. 获取 blob 文件的标准路径
-	path, err := d.blobDataPath(params.Digest)
-	if err != nil {
-		return nil, types.NewError(types.ErrorCodeDigestInvalid, "invalid digest", err.Error())
-	}
-
-	// 2. 使用 os.Stat 检查文件是否存在并获取信息
-	info, err := os.Stat(path)
+	// 1. 检查 blob 是否链接到仓库并获取文件信息
+	path, info, err := d.statLinkedBlob(params.RepositoryName, params.Digest)
 	if err != nil {
-		if os.IsNotExist(err) {
-			// 文件不存在，返回标准的 blob unknown 错误
-			return nil, types.NewError(types.ErrorCodeBlobUnknown, "blob unknown", map[string]string{"digest": params.Digest})
-		}
-		// 其他文件系统错误
 		return nil, err
 	}
 
-	// 3. 打开文件用于读取
+	// 2. 打开文件用于读取
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 
-	// 4. 构建并返回 BlobStatus
+	// 3. 构建并返回 BlobStatus
 	status := &types.BlobStatus{
 		Digest:        params.Digest,
 		ContentLength: int(info.Size()),
@@ -380,6 +1205,11 @@ func (d *fileSystemDriver) RetrieveBlob(params types.GetBlobParams) (*types.Blob
 }
 
 func (d *fileSystemDriver) GetBlobUploadStatus(params types.GetBlobParams) (*types.BlobUploadStatus, error) {
+	// 校验引用语法
+	if err := firstError(types.ValidateRepositoryName(params.RepositoryName), types.ValidateUploadUUID(params.UUID)); err != nil {
+		return nil, err
+	}
+
 	// 1. 获取临时上传目录的路径
 	uploadPath := d.blobUploadPath(params.RepositoryName, params.UUID)
 
@@ -395,35 +1225,36 @@ func (d *fileSystemDriver) GetBlobUploadStatus(params types.GetBlobParams) (*typ
 		return nil, types.NewError(types.ErrorCodeBlobUploadUnknown, "blob upload unknown", map[string]string{"uuid": params.UUID})
 	}
 
-	// 3. 检查临时数据文件的大小以确定当前偏移量
-	dataPath := filepath.Join(uploadPath, "data")
-	fileInfo, err := os.Stat(dataPath)
-	var offset int64
+	// 3. 读取已提交的偏移量，不包含进行中的追加可能回滚的数据
+	offset, err := d.committedUploadOffset(params.RepositoryName, params.UUID)
 	if err != nil {
-		if !os.IsNotExist(err) {
-			return nil, err // 其他文件系统错误
-		}
-		// 文件不存在是正常情况，意味着上传刚开始，偏移量为 0
-		offset = 0
-	} else {
-		offset = fileInfo.Size()
+		return nil, err
 	}
 
 	// 4. 构建并返回状态
-	rangeStr := "0-0"
-	if offset > 0 {
-		rangeStr = fmt.Sprintf("0-%d", offset-1)
-	}
 	status := &types.BlobUploadStatus{
 		UUID:     params.UUID,
 		Location: fmt.Sprintf("/v2/%s/blobs/uploads/%s", params.RepositoryName, params.UUID),
-		Range:    rangeStr,
+		Range:    uploadRange(offset),
 	}
 
 	return status, nil
 }
 
 func (d *fileSystemDriver) CompleteBlobUpload(params types.CompleteBlobUploadParams) (*types.CompleteBlobUploadResponse, error) {
+	// 校验引用语法
+	if err := firstError(types.ValidateRepositoryName(params.RepositoryName), types.ValidateUploadUUID(params.UUID), types.ValidateDigest(params.Digest)); err != nil {
+		return nil, err
+	}
+
+	// 持有上传会话锁，同一会话的并发请求（如客户端重试）依次执行，
+	// 后到的请求会看到前一个请求写入后的偏移量
+	unlock, err := d.lockUpload(params.RepositoryName, params.UUID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	// 1. 获取临时上传目录的路径
 	uploadPath := d.blobUploadPath(params.RepositoryName, params.UUID)
 	dataPath := filepath.Join(uploadPath, "data")
@@ -433,25 +1264,14 @@ func (d *fileSystemDriver) CompleteBlobUpload(params types.CompleteBlobUploadPar
 		return nil, types.NewError(types.ErrorCodeBlobUploadUnknown, "blob upload unknown", map[string]string{"uuid": params.UUID})
 	}
 
-	// 3. 如果请求体有数据，将数据写入临时文件
-	if len(params.Data) > 0 {
-		if err := os.WriteFile(dataPath, params.Data, 0644); err != nil {
-			return nil, err
-		}
-	}
-
-	// 4. 读取或获取最终的文件内容并计算摘要
-	var content []byte
-	var err error
-	if len(params.Data) > 0 {
-		content = params.Data
-	} else {
-		content, err = os.ReadFile(dataPath)
-		if err != nil {
-			return nil, err
-		}
+	// 3. 将请求体中可能携带的最后一块数据追加到已上传的数据之后（而不是覆盖），
+	// 并从保存的哈希状态继续计算，无需重新读取整个数据文件。
+	// 上传空 blob 时数据文件会在这里被创建
+	size, hasher, err := d.appendUploadData(params.RepositoryName, params.UUID, params.Body, params.HasRange, params.RangeFrom, params.RangeTo)
+	if err != nil {
+		return nil, err
 	}
-	calculatedDigest := calculateDigest(content)
+	calculatedDigest := fmt.Sprintf("sha256:%x", hasher.Sum(nil))
 
 	// 4. 校验摘要
 	if params.Digest != calculatedDigest {
@@ -464,30 +1284,58 @@ func (d *fileSystemDriver) CompleteBlobUpload(params types.CompleteBlobUploadPar
 		return nil, err
 	}
 
-	// 6. 创建最终目录并移动文件
-	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
+	// 6. 先将数据 fsync 到磁盘，再创建最终目录并移动文件，
+	// 保证 blob 出现在最终路径时内容已经完整落盘。
+	// 移动和链接之间不允许垃圾回收清除该 blob
+	if err := d.syncUploadData(dataPath); err != nil {
+		return nil, err
+	}
+	endWrite := d.gc.beginWrite(params.Digest)
+	defer endWrite()
+	if err := d.mkdirAll(filepath.Dir(finalPath)); err != nil {
 		return nil, err
 	}
 	if err := os.Rename(dataPath, finalPath); err != nil {
 		return nil, err
 	}
+	if err := d.syncDir(filepath.Dir(finalPath)); err != nil {
+		return nil, err
+	}
 
-	// 7. 清理临时上传目录
+	// 7. 将 blob 链接到仓库，之后才能通过该仓库访问
+	if err := d.linkBlob(params.RepositoryName, params.Digest); err != nil {
+		return nil, err
+	}
+
+	// 8. 清理临时上传目录
 	if err := os.RemoveAll(uploadPath); err != nil {
 		// 注意：即使清理失败，上传也已成功，这里可以只记录日志而不返回错误
 	}
 
-	// 8. 构建并返回成功响应
+	// 9. 构建并返回成功响应
 	response := &types.CompleteBlobUploadResponse{
 		Digest:        params.Digest,
 		Location:      fmt.Sprintf("/v2/%s/blobs/%s", params.RepositoryName, params.Digest),
-		ContentLength: len(content),
+		ContentLength: int(size),
 	}
 
 	return response, nil
 }
 
 func (d *fileSystemDriver) UploadBlobChunk(params types.UploadBlobChunkParams) (*types.UploadBlobChunkResponse, error) {
+	// 校验引用语法
+	if err := firstError(types.ValidateRepositoryName(params.RepositoryName), types.ValidateUploadUUID(params.UUID)); err != nil {
+		return nil, err
+	}
+
+	// 持有上传会话锁，同一会话的并发请求（如客户端重试）依次执行，
+	// 后到的请求会看到前一个请求写入后的偏移量
+	unlock, err := d.lockUpload(params.RepositoryName, params.UUID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	// 1. 获取临时上传目录的路径
 	uploadPath := d.blobUploadPath(params.RepositoryName, params.UUID)
 
@@ -496,36 +1344,17 @@ func (d *fileSystemDriver) UploadBlobChunk(params types.UploadBlobChunkParams) (
 		return nil, types.NewError(types.ErrorCodeBlobUploadUnknown, "blob upload unknown", map[string]string{"uuid": params.UUID})
 	}
 
-	// 3. 获取当前文件大小以校验 Range
-	dataPath := filepath.Join(uploadPath, "data")
-	var currentSize int64
-	if info, err := os.Stat(dataPath); err == nil {
-		currentSize = info.Size()
-	} else if !os.IsNotExist(err) {
-		return nil, err
-	}
-
-	// 4. 校验 Range
-	if params.RangeFrom != currentSize {
-		return nil, types.NewError(types.ErrorCodeRangeInvalid, "invalid range", fmt.Sprintf("expected range start %d, got %d", currentSize, params.RangeFrom))
-	}
-
-	// 5. 打开文件并追加数据
-	file, err := os.OpenFile(dataPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-	bytesWritten, err := file.Write(params.Content)
+	// 3. 校验 Range 并追加数据
+	newOffset, _, err := d.appendUploadData(params.RepositoryName, params.UUID, params.Body, params.HasRange, params.RangeFrom, params.RangeTo)
 	if err != nil {
 		return nil, err
 	}
 
-	// 6. 构建并返回响应
-	newOffset := currentSize + int64(bytesWritten)
+	// 4. 构建并返回响应
+	// Range 表示目前已接收的全部数据范围，与 GetBlobUploadStatus 保持一致
 	response := &types.UploadBlobChunkResponse{
 		Location: fmt.Sprintf("/v2/%s/blobs/uploads/%s", params.RepositoryName, params.UUID),
-		Range:    fmt.Sprintf("%d-%d", currentSize, newOffset-1),
+		Range:    uploadRange(newOffset),
 		UUID:     params.UUID,
 	}
 
@@ -533,6 +1362,18 @@ func (d *fileSystemDriver) UploadBlobChunk(params types.UploadBlobChunkParams) (
 }
 
 func (d *fileSystemDriver) CancelBlobUpload(params types.GetBlobParams) (int, error) {
+	// 校验引用语法
+	if err := firstError(types.ValidateRepositoryName(params.RepositoryName), types.ValidateUploadUUID(params.UUID)); err != nil {
+		return 0, err
+	}
+
+	// 等待进行中的追加完成后再删除会话
+	unlock, err := d.lockUpload(params.RepositoryName, params.UUID)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
 	// 1. 获取临时上传目录的路径
 	uploadPath := d.blobUploadPath(params.RepositoryName, params.UUID)
 
@@ -542,7 +1383,7 @@ func (d *fileSystemDriver) CancelBlobUpload(params types.GetBlobParams) (int, er
 	}
 
 	// 3. 删除整个临时上传目录
-	err := os.RemoveAll(uploadPath)
+	err = os.RemoveAll(uploadPath)
 	if err != nil {
 		return 0, err // 如果删除失败，返回一个通用的服务器错误
 	}
@@ -550,3 +1391,34 @@ func (d *fileSystemDriver) CancelBlobUpload(params types.GetBlobParams) (int, er
 	// 4. 成功，返回 204 No Content 状态码
 	return 204, nil
 }
+
+func (d *fileSystemDriver) DeleteBlob(params types.GetBlobParams) error {
+	// 校验引用语法
+	if err := firstError(types.ValidateRepositoryName(params.RepositoryName), types.ValidateDigest(params.Digest)); err != nil {
+		return err
+	}
+
+	// 与 PutManifest 一致，先获取写屏障再获取仓库锁
+	endWrite := d.gc.beginWrite(params.Digest)
+	defer endWrite()
+
+	// 持有仓库锁，删除链接不会插入到并发的 PutManifest 的引用校验与写入之间
+	unlock, err := d.lockRepository(params.RepositoryName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// 1. 检查 blob 是否链接到仓库
+	if _, _, err := d.statLinkedBlob(params.RepositoryName, params.Digest); err != nil {
+		return err
+	}
+
+	// 2. 只删除仓库对 blob 的链接，其他仓库可能仍在使用该 blob，
+	// 数据文件由垃圾回收在没有任何引用时清理
+	if err := os.RemoveAll(filepath.Dir(d.layerLinkPath(params.RepositoryName, params.Digest))); err != nil {
+		return err
+	}
+
+	return nil
+}
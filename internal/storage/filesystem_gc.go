@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"fmt"
+	"my_docker_registry/internal/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// GarbageCollect 对整个存储执行标记-清除垃圾回收。
+// 标记阶段遍历每个仓库中保留的 manifest，记录它们引用的 config 和 layer；
+// 清除阶段删除未被标记的 blob 以及各仓库中指向它们的链接。
+// 可以在服务运行时执行：GracePeriod 内新上传或链接的 blob 和新写入的 manifest 不会被回收，
+// 清除阶段独占写屏障，标记阶段以来上传、挂载或被 manifest 引用的 blob 同样不会被回收。
+func (d *fileSystemDriver) GarbageCollect(options types.GarbageCollectOptions) (*types.GarbageCollectResult, error) {
+	if !d.gc.start() {
+		return nil, fmt.Errorf("garbage collection is already running")
+	}
+	defer d.gc.finish()
+
+	result := &types.GarbageCollectResult{}
+
+	// 1. 枚举所有仓库
+	catalog, err := d.ListRepositories(types.ListRepositoriesParams{N: -1})
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. 标记：收集所有保留的 manifest 引用的 blob，必要时删除不可达的 manifest
+	marked := make(map[string]bool)
+	for _, repoName := range catalog.Repositories {
+		if err := d.markRepository(repoName, options, marked, result); err != nil {
+			return nil, fmt.Errorf("mark repository %s: %w", repoName, err)
+		}
+	}
+
+	// 3. 清除：阻止新的写入，删除未被标记的 blob
+	endSweep := d.gc.beginSweep(marked)
+	defer endSweep()
+	if err := d.sweepBlobs(options, marked, result); err != nil {
+		return nil, fmt.Errorf("sweep blobs: %w", err)
+	}
+
+	// 4. 删除仓库中指向已被清除的 blob 的链接
+	for _, repoName := range catalog.Repositories {
+		if err := d.sweepLayerLinks(repoName, options, marked, result); err != nil {
+			return nil, fmt.Errorf("sweep layer links of %s: %w", repoName, err)
+		}
+	}
+
+	return result, nil
+}
+
+// markRepository 标记仓库中保留的 manifest 引用的 blob。
+// 启用 DeleteUntagged 时只保留从 tag 可达的 manifest，其余的被删除（DryRun 时只记录）。
+func (d *fileSystemDriver) markRepository(repoName string, options types.GarbageCollectOptions, marked map[string]bool, result *types.GarbageCollectResult) error {
+	unlock, err := d.lockRepository(repoName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// 1. 枚举仓库中的所有 manifest
+	revisions, err := d.listRevisions(repoName)
+	if err != nil {
+		return err
+	}
+
+	// 2. 确定需要保留的 manifest，不删除未打 tag 的 manifest 时全部保留
+	var keep map[string]bool
+	if options.DeleteUntagged {
+		tags, err := d.tagDigests(repoName)
+		if err != nil {
+			return err
+		}
+		if keep, err = reachableRevisions(tags, d.revisionReader(repoName), d.referrersLister(repoName)); err != nil {
+			return err
+		}
+	}
+
+	// 3. 标记保留的 manifest 引用的 blob，删除其余的 manifest 并更新受影响的 referrers tag
+	deleteRevision := func(digest string) (string, error) {
+		return d.deleteRevision(repoName, digest)
+	}
+	updateReferrersTag := func(subject string) error {
+		return d.updateReferrersTag(repoName, subject)
+	}
+	if err := markRevisions(repoName, revisions, keep, options, d.revisionReader(repoName), deleteRevision, updateReferrersTag, marked, result); err != nil {
+		return err
+	}
+
+	// 4. 标记宽限期内上传或挂载到仓库的 blob，它们的 manifest 可能还没有推送
+	return d.markRecentLayerLinks(repoName, options.GracePeriod, marked)
+}
+
+// markRecentLayerLinks 标记仓库中宽限期内创建的 blob 链接所指向的 blob。
+// 挂载已有的 blob 时 blob 本身可能很旧，因此以链接的修改时间为准
+func (d *fileSystemDriver) markRecentLayerLinks(repoName string, gracePeriod time.Duration, marked map[string]bool) error {
+	if gracePeriod <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(filepath.Join(d.repositoryPath(repoName), "_layers", "sha256"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		digest := "sha256:" + entry.Name()
+		info, err := os.Stat(d.layerLinkPath(repoName, digest))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if withinGracePeriod(info.ModTime(), gracePeriod) {
+			marked[digest] = true
+		}
+	}
+	return nil
+}
+
+// listRevisions 返回仓库中所有 manifest 的摘要
+func (d *fileSystemDriver) listRevisions(repoName string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(d.repositoryPath(repoName), "_manifests", "revisions", "sha256"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	revisions := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), tempFilePrefix) {
+			continue
+		}
+		revisions = append(revisions, "sha256:"+entry.Name())
+	}
+	return revisions, nil
+}
+
+// sweepBlobs 删除所有未被标记的 blob 数据文件
+func (d *fileSystemDriver) sweepBlobs(options types.GarbageCollectOptions, marked map[string]bool, result *types.GarbageCollectResult) error {
+	root := filepath.Join(d.rootDirectory, "blobs", "sha256")
+	return filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), tempFilePrefix) {
+			return nil
+		}
+
+		digest := "sha256:" + entry.Name()
+		if marked[digest] {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if withinGracePeriod(info.ModTime(), options.GracePeriod) {
+			// 新上传的 blob 还没有被链接记录标记时也不回收，并保留它的链接
+			marked[digest] = true
+			return nil
+		}
+		result.BlobsDeleted = append(result.BlobsDeleted, digest)
+		result.BytesReclaimed += info.Size()
+		if options.DryRun {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+// sweepLayerLinks 删除仓库中指向未被标记的 blob 的链接
+func (d *fileSystemDriver) sweepLayerLinks(repoName string, options types.GarbageCollectOptions, marked map[string]bool, result *types.GarbageCollectResult) error {
+	unlock, err := d.lockRepository(repoName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	layersPath := filepath.Join(d.repositoryPath(repoName), "_layers", "sha256")
+	entries, err := os.ReadDir(layersPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		if marked["sha256:"+entry.Name()] {
+			continue
+		}
+		result.LayerLinksDeleted++
+		if options.DryRun {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(layersPath, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"fmt"
+	"my_docker_registry/internal/types"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 迁移完成后在 _migrations 目录下写入同名的标记文件
+const (
+	// layerLinksMigration 回填仓库的 blob 链接（_layers）
+	layerLinksMigration = "layer-links"
+	// tagRefsMigration 回填 tag 的反向索引（tagrefs）
+	tagRefsMigration = "tag-refs"
+)
+
+// migrationMarkerPath 构建记录迁移已完成的标记文件路径。
+// 路径格式: <root>/_migrations/<name>
+func (d *fileSystemDriver) migrationMarkerPath(name string) string {
+	return filepath.Join(d.rootDirectory, "_migrations", name)
+}
+
+// migrate 将旧版本写入的数据迁移到当前的布局，每个迁移只执行一次：
+//   - 升级前 blob 只存放在全局存储中，升级后只有链接到仓库的 blob 才能通过该仓库访问，
+//     因此将每个仓库中已有 manifest 引用的 config 和 layer 链接到该仓库。
+//     只上传而尚未被任何 manifest 引用的 blob 无法确定所属仓库，不会被链接，需要客户端重新推送。
+//   - 升级前创建的 tag 没有反向索引，删除 manifest 时无法找到并删除它们，因此为每个 tag 补建反向索引。
+func (d *fileSystemDriver) migrate() error {
+	migrations := []struct {
+		name    string
+		migrate func(repoName string) error
+	}{
+		{layerLinksMigration, d.linkRevisionBlobs},
+		{tagRefsMigration, d.indexTagRefs},
+	}
+	for _, migration := range migrations {
+		if err := d.runMigration(migration.name, migration.migrate); err != nil {
+			return fmt.Errorf("migration %s: %w", migration.name, err)
+		}
+	}
+	return nil
+}
+
+// runMigration 对每个仓库执行一次迁移，完成后写入标记文件，之后启动时直接跳过
+func (d *fileSystemDriver) runMigration(name string, migrate func(repoName string) error) error {
+	// 1. 已经迁移过则跳过
+	markerPath := d.migrationMarkerPath(name)
+	if _, err := os.Stat(markerPath); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// 2. 迁移每个仓库
+	catalog, err := d.ListRepositories(types.ListRepositoriesParams{N: -1})
+	if err != nil {
+		return err
+	}
+	for _, repoName := range catalog.Repositories {
+		if err := migrate(repoName); err != nil {
+			return fmt.Errorf("repository %s: %w", repoName, err)
+		}
+	}
+
+	// 3. 写入标记文件
+	return d.writeFile(markerPath, []byte(time.Now().UTC().Format(time.RFC3339)))
+}
+
+// linkRevisionBlobs 将仓库中所有 manifest 引用的 blob 链接到仓库，已链接或数据不存在的 blob 跳过
+func (d *fileSystemDriver) linkRevisionBlobs(repoName string) error {
+	revisions, err := d.listRevisions(repoName)
+	if err != nil {
+		return err
+	}
+	for _, digest := range revisions {
+		content, err := os.ReadFile(d.manifestPath(repoName, digest))
+		if err != nil {
+			return err
+		}
+		mediaType, err := d.manifestMediaType(repoName, digest, content)
+		if err != nil {
+			return err
+		}
+
+		_, blobs := manifestReferences(mediaType, content)
+		for _, blob := range blobs {
+			if _, err := os.Stat(d.layerLinkPath(repoName, blob)); err == nil {
+				continue
+			}
+			path, err := d.blobDataPath(blob)
+			if err != nil {
+				return err
+			}
+			if _, err := os.Stat(path); err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return err
+			}
+			if err := d.linkBlob(repoName, blob); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexTagRefs 为仓库中的每个 tag 补建反向索引，链接文件损坏的 tag 跳过
+func (d *fileSystemDriver) indexTagRefs(repoName string) error {
+	tags, err := d.tagDigests(repoName)
+	if err != nil {
+		return err
+	}
+	for tagName, digest := range tags {
+		tagRefPath := filepath.Join(d.tagRefsPath(repoName, digest), tagName)
+		if _, err := os.Stat(tagRefPath); err == nil {
+			continue
+		}
+		if err := d.writeFile(tagRefPath, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"my_docker_registry/internal/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PurgeUploads 删除所有仓库中创建时间早于 MaxAge 的上传会话。
+// 客户端中断的推送不会完成或取消会话，其已上传的数据会一直占用磁盘。
+// 会话被删除后，GetBlobUploadStatus 等操作返回 BLOB_UPLOAD_UNKNOWN。
+func (d *fileSystemDriver) PurgeUploads(options types.PurgeUploadsOptions) (*types.PurgeUploadsResult, error) {
+	result := &types.PurgeUploadsResult{}
+
+	// 遍历 repositories 目录，找到每个仓库的 _uploads 目录。
+	// 只有上传会话而没有完成任何上传的仓库不会出现在 catalog 中，因此不能通过 ListRepositories 枚举
+	root := filepath.Join(d.rootDirectory, "repositories")
+	err := filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !entry.IsDir() || path == root || !strings.HasPrefix(entry.Name(), "_") {
+			return nil
+		}
+
+		if entry.Name() == "_uploads" {
+			repoName, err := filepath.Rel(root, filepath.Dir(path))
+			if err != nil {
+				return err
+			}
+			if err := d.purgeRepositoryUploads(filepath.ToSlash(repoName), options.MaxAge, result); err != nil {
+				return err
+			}
+		}
+		// _manifests、_uploads 等是仓库的内部目录，不再向下查找
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// purgeRepositoryUploads 删除仓库中过期的上传会话
+func (d *fileSystemDriver) purgeRepositoryUploads(repoName string, maxAge time.Duration, result *types.PurgeUploadsResult) error {
+	entries, err := os.ReadDir(filepath.Join(d.repositoryPath(repoName), "_uploads"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || types.ValidateUploadUUID(entry.Name()) != nil {
+			continue
+		}
+		uuid := entry.Name()
+
+		startedAt, err := d.uploadStartedAt(repoName, uuid)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if time.Since(startedAt) < maxAge {
+			continue
+		}
+
+		size, purged, err := d.purgeUpload(repoName, uuid)
+		if err != nil {
+			return err
+		}
+		if !purged {
+			continue
+		}
+		result.UploadsPurged = append(result.UploadsPurged, repoName+"/"+uuid)
+		result.BytesReclaimed += size
+	}
+	return nil
+}
+
+// uploadStartedAt 返回上传会话的创建时间。
+// 旧版本创建的会话或 startedat 文件损坏时，以会话目录的修改时间代替
+func (d *fileSystemDriver) uploadStartedAt(repoName, uuid string) (time.Time, error) {
+	content, err := os.ReadFile(d.uploadStartedAtPath(repoName, uuid))
+	if err == nil {
+		if startedAt, err := time.Parse(time.RFC3339, strings.TrimSpace(string(content))); err == nil {
+			return startedAt, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return time.Time{}, err
+	}
+
+	info, err := os.Stat(d.blobUploadPath(repoName, uuid))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// purgeUpload 持有上传会话锁删除会话目录，返回被删除的已上传数据的字节数。
+// 会话在获取锁期间已被完成或取消时返回 false
+func (d *fileSystemDriver) purgeUpload(repoName, uuid string) (int64, bool, error) {
+	// 等待进行中的追加完成，避免删除正在写入的数据文件
+	unlock, err := d.lockUpload(repoName, uuid)
+	if err != nil {
+		if regErr, ok := err.(types.RegistryError); ok && regErr.Code == types.ErrorCodeBlobUploadUnknown {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	defer unlock()
+
+	uploadPath := d.blobUploadPath(repoName, uuid)
+	if _, err := os.Stat(uploadPath); err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	var size int64
+	if info, err := os.Stat(filepath.Join(uploadPath, "data")); err == nil {
+		size = info.Size()
+	}
+	if err := os.RemoveAll(uploadPath); err != nil {
+		return 0, false, err
+	}
+	return size, true, nil
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"my_docker_registry/internal/types"
+)
+
+// newTestFileSystemDriver 在临时目录中创建 fileSystemDriver
+func newTestFileSystemDriver(t *testing.T) *fileSystemDriver {
+	t.Helper()
+	driver, err := NewFileSystemDriver(t.TempDir(), FileSystemOptions{DisableFsync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return driver.(*fileSystemDriver)
+}
+
+// putBlob 以单请求上传的方式推送 blob，返回其摘要
+func putBlob(t *testing.T, driver StorageDriver, repo, data string) string {
+	t.Helper()
+	digest := types.CalculateDigest([]byte(data))
+	if _, err := driver.InitiateBlobUpload(types.InitiateBlobUploadParams{
+		RepositoryName: repo,
+		Digest:         digest,
+		Body:           strings.NewReader(data),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return digest
+}
+
+func TestFileSystemConcurrentChunksAtSameOffset(t *testing.T) {
+	driver := newTestFileSystemDriver(t)
+	initiated, err := driver.InitiateBlobUpload(types.InitiateBlobUploadParams{RepositoryName: "library/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uuid := initiated.InitiatedStatus.UUID
+
+	// 1. 多个客户端同时上传起始位置相同的数据块，只能有一个成功
+	const writers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := driver.UploadBlobChunk(types.UploadBlobChunkParams{
+				RepositoryName: "library/app",
+				UUID:           uuid,
+				Body:           strings.NewReader("hello"),
+				HasRange:       true,
+				RangeFrom:      0,
+				RangeTo:        4,
+			})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		if regErr, ok := err.(types.RegistryError); !ok || regErr.Code != types.ErrorCodeRangeInvalid {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("%d chunks succeeded, want 1", succeeded)
+	}
+
+	// 2. 状态中的偏移量只包含被接受的数据块
+	status, err := driver.GetBlobUploadStatus(types.GetBlobParams{RepositoryName: "library/app", UUID: uuid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Range != "0-4" {
+		t.Fatalf("Range = %q, want 0-4", status.Range)
+	}
+}
+
+func TestFileSystemGarbageCollect(t *testing.T) {
+	driver := newTestFileSystemDriver(t)
+	repo := "library/app"
+	config := putBlob(t, driver, repo, `{"architecture":"amd64","os":"linux"}`)
+	layer := putBlob(t, driver, repo, "layer")
+	orphan := putBlob(t, driver, repo, "orphan")
+
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":%q,"size":1},"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","digest":%q,"size":1}]}`,
+		types.OCIManifestMediaType, config, layer)
+	if _, err := driver.PutManifest(types.PutManifestParams{
+		RepositoryName: repo,
+		Reference:      "latest",
+		MediaType:      types.OCIManifestMediaType,
+		Body:           strings.NewReader(manifest),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 1. 宽限期内上传的 blob 不会被回收
+	result, err := driver.GarbageCollect(types.GarbageCollectOptions{GracePeriod: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.BlobsDeleted) != 0 {
+		t.Fatalf("deleted %v within the grace period", result.BlobsDeleted)
+	}
+
+	// 2. 没有宽限期时只回收未被 manifest 引用的 blob 及其链接
+	result, err = driver.GarbageCollect(types.GarbageCollectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.BlobsDeleted) != 1 || result.BlobsDeleted[0] != orphan || result.LayerLinksDeleted != 1 {
+		t.Fatalf("result = %+v, want only %s deleted", result, orphan)
+	}
+	for _, digest := range []string{config, layer} {
+		if _, err := driver.BlobExists(types.GetBlobParams{RepositoryName: repo, Digest: digest}); err != nil {
+			t.Fatalf("referenced blob %s: %v", digest, err)
+		}
+	}
+	if _, err := driver.BlobExists(types.GetBlobParams{RepositoryName: repo, Digest: orphan}); err == nil {
+		t.Fatalf("orphan blob %s still exists", orphan)
+	}
+
+	// 3. 删除 tag 后启用 DeleteUntagged 时 manifest 及其 blob 一并回收
+	if err := driver.DeleteManifest(types.GetManifestParams{RepositoryName: repo, Reference: "latest"}); err != nil {
+		t.Fatal(err)
+	}
+	result, err = driver.GarbageCollect(types.GarbageCollectOptions{DeleteUntagged: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.ManifestsDeleted) != 1 || len(result.BlobsDeleted) != 2 {
+		t.Fatalf("result = %+v, want the manifest and its two blobs deleted", result)
+	}
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"encoding/json"
+	"my_docker_registry/internal/types"
+	"sort"
+	"time"
+)
+
+// revisionFunc 读取仓库中的 manifest，返回媒体类型、内容和写入时间，manifest 不存在时 content 为 nil
+type revisionFunc func(digest string) (mediaType string, content []byte, writtenAt time.Time, err error)
+
+// referrersFunc 返回 referrers 索引中以 subject 为 subject 的 manifest
+type referrersFunc func(subject string) ([]string, error)
+
+// withinGracePeriod 判断写入时间是否在宽限期内
+func withinGracePeriod(writtenAt time.Time, gracePeriod time.Duration) bool {
+	return gracePeriod > 0 && time.Since(writtenAt) < gracePeriod
+}
+
+// reachableRevisions 返回从 tag 可达的 manifest：tag 直接指向的 manifest、
+// manifest list / OCI index 的子 manifest，以及被保留的 manifest 的 referrers。
+// referrers tag（sha256-<hex>）不作为起点，它指向的 index 只在 subject 被保留时保留。
+// tags 是仓库中 tag 到 digest 的映射
+func reachableRevisions(tags map[string]string, readRevision revisionFunc, listReferrers referrersFunc) (map[string]bool, error) {
+	// 1. 收集所有 tag 指向的 manifest
+	var pending []string
+	referrersIndexes := make(map[string]string)
+	for tagName, digest := range tags {
+		if subject, ok := referrersTagSubject(tagName); ok {
+			referrersIndexes[subject] = digest
+			continue
+		}
+		pending = append(pending, digest)
+	}
+
+	// 2. 从 tag 出发遍历子 manifest 和 referrers
+	keep := make(map[string]bool)
+	for len(pending) > 0 {
+		digest := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if keep[digest] {
+			continue
+		}
+		keep[digest] = true
+
+		mediaType, content, _, err := readRevision(digest)
+		if err != nil {
+			return nil, err
+		}
+		if content == nil {
+			continue
+		}
+		children, _ := manifestReferences(mediaType, content)
+		pending = append(pending, children...)
+
+		referrers, err := listReferrers(digest)
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, referrers...)
+		if index, ok := referrersIndexes[digest]; ok {
+			pending = append(pending, index)
+		}
+	}
+	return keep, nil
+}
+
+// markRevisions 标记仓库中保留的 manifest 引用的 blob，删除其余的 manifest（DryRun 时只记录）。
+// keep 为 nil 时保留所有 manifest；宽限期内写入的 manifest 可能是多架构镜像中先于 index 推送的子 manifest，暂时保留。
+// deleteRevision 返回被删除的 manifest 的 subject，所有 manifest 删除后再统一更新受影响的 referrers tag，
+// 避免生成中间状态的 index
+func markRevisions(repoName string, revisions []string, keep map[string]bool, options types.GarbageCollectOptions,
+	readRevision revisionFunc, deleteRevision func(digest string) (string, error), updateReferrersTag func(subject string) error,
+	marked map[string]bool, result *types.GarbageCollectResult) error {
+	subjects := make(map[string]bool)
+	for _, digest := range revisions {
+		mediaType, content, writtenAt, err := readRevision(digest)
+		if err != nil {
+			return err
+		}
+		if content == nil {
+			continue
+		}
+
+		if keep != nil && !keep[digest] && !withinGracePeriod(writtenAt, options.GracePeriod) {
+			result.ManifestsDeleted = append(result.ManifestsDeleted, repoName+"@"+digest)
+			result.BytesReclaimed += int64(len(content))
+			if options.DryRun {
+				continue
+			}
+			subject, err := deleteRevision(digest)
+			if err != nil {
+				return err
+			}
+			if subject != "" {
+				subjects[subject] = true
+			}
+			continue
+		}
+
+		_, blobs := manifestReferences(mediaType, content)
+		for _, blob := range blobs {
+			marked[blob] = true
+		}
+	}
+
+	for subject := range subjects {
+		if err := updateReferrersTag(subject); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// referrersIndex 构建 subject 的 referrers 列表，按 digest 排序，已被删除的 referrer 跳过
+func referrersIndex(referrers []string, readRevision revisionFunc) (*types.ManifestList, error) {
+	digests := append([]string(nil), referrers...)
+	sort.Strings(digests)
+
+	index := &types.ManifestList{
+		SchemaVersion: 2,
+		MediaType:     types.OCIIndexMediaType,
+		Manifests:     []types.ManifestListDescriptor{},
+	}
+	for _, digest := range digests {
+		mediaType, content, _, err := readRevision(digest)
+		if err != nil {
+			return nil, err
+		}
+		if content == nil {
+			continue
+		}
+		index.Manifests = append(index.Manifests, referrerDescriptor(mediaType, digest, content))
+	}
+	return index, nil
+}
+
+// syncReferrersTag 按 OCI distribution spec 的 referrers tag schema，
+// 将 subject 当前的 referrers 列表写成一个 OCI index 并以 sha256-<hex> tag 指向它，
+// 供不支持 referrers API 的客户端（如旧版 cosign）发现签名。没有 referrer 时删除该 tag。
+// 被替换的旧 index 不再有 tag 指向，留给垃圾回收处理
+func syncReferrersTag(subject string, index *types.ManifestList, writeIndex func(digest string, content []byte) error,
+	setTag func(tagName, digest string) error, removeTag func(tagName string) error) error {
+	tagName := referrersTagName(subject)
+	if len(index.Manifests) == 0 {
+		return removeTag(tagName)
+	}
+
+	content, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	digest := calculateDigest(content)
+	if err := writeIndex(digest, content); err != nil {
+		return err
+	}
+	return setTag(tagName, digest)
+}
@@ -0,0 +1,808 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"my_docker_registry/internal/types"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// inMemoryDriver 实现了 StorageDriver 接口，所有数据保存在内存中，进程退出即丢失。
+// 适用于测试以及临时的缓存 registry。
+// mu 保护所有元数据；上传会话另有自己的锁，读取请求体时不持有 mu，
+// 加锁顺序总是先会话锁再 mu。
+type inMemoryDriver struct {
+	mu           sync.RWMutex
+	repositories map[string]*memoryRepository
+	// blobs 是全局的内容寻址存储，仓库通过 layers 链接访问
+	blobs map[string]*memoryBlob
+}
+
+// memoryRepository 保存一个仓库的 manifest、tag、referrers 索引、blob 链接和上传会话
+type memoryRepository struct {
+	manifests map[string]*memoryManifest
+	tags      map[string]string
+	// tagRefs 是 tag 的反向索引：digest -> 指向它的 tag
+	tagRefs map[string]map[string]bool
+	// referrers 是 referrers 索引：subject -> 以它为 subject 的 manifest
+	referrers map[string]map[string]bool
+	// layers 记录链接到仓库的 blob 及链接时间
+	layers  map[string]time.Time
+	uploads map[string]*memoryUpload
+}
+
+type memoryManifest struct {
+	content   []byte
+	mediaType string
+	createdAt time.Time
+}
+
+type memoryBlob struct {
+	data      []byte
+	createdAt time.Time
+}
+
+// memoryUpload 是一个上传会话，hasher 即为已上传数据的哈希状态
+type memoryUpload struct {
+	mu        sync.Mutex
+	data      []byte
+	hasher    hash.Hash
+	startedAt time.Time
+	// size 是已提交的数据长度，由 d.mu 保护，查询上传状态时不必等待进行中的追加
+	size int
+	// removed 表示会话已被完成、取消或清理，持有会话锁后必须检查
+	removed bool
+}
+
+// readSeekNopCloser 为 bytes.Reader 提供空的 Close 方法
+type readSeekNopCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekNopCloser) Close() error { return nil }
+
+// NewInMemoryDriver 创建一个新的 inMemoryDriver 实例。
+func NewInMemoryDriver() StorageDriver {
+	return &inMemoryDriver{
+		repositories: make(map[string]*memoryRepository),
+		blobs:        make(map[string]*memoryBlob),
+	}
+}
+
+// 预处理函数
+
+// repository 返回仓库，create 为 true 时不存在则创建。调用方需持有 mu
+func (d *inMemoryDriver) repository(repoName string, create bool) *memoryRepository {
+	repo, ok := d.repositories[repoName]
+	if !ok && create {
+		repo = &memoryRepository{
+			manifests: make(map[string]*memoryManifest),
+			tags:      make(map[string]string),
+			tagRefs:   make(map[string]map[string]bool),
+			referrers: make(map[string]map[string]bool),
+			layers:    make(map[string]time.Time),
+			uploads:   make(map[string]*memoryUpload),
+		}
+		d.repositories[repoName] = repo
+	}
+	return repo
+}
+
+// resolveReference 将 tag 解析为 digest，digest 原样返回。调用方需持有 mu
+func (d *inMemoryDriver) resolveReference(repo *memoryRepository, reference string) (string, error) {
+	if strings.HasPrefix(reference, "sha256:") {
+		return reference, nil
+	}
+	if repo != nil {
+		if digest, ok := repo.tags[reference]; ok {
+			return digest, nil
+		}
+	}
+	return "", types.NewError(types.ErrorCodeManifestUnknown, "manifest unknown", map[string]string{"reference": reference})
+}
+
+// lookupManifest 解析引用并返回 manifest。调用方需持有 mu
+func (d *inMemoryDriver) lookupManifest(repoName, reference string) (string, *memoryManifest, error) {
+	repo := d.repository(repoName, false)
+	digest, err := d.resolveReference(repo, reference)
+	if err != nil {
+		return "", nil, err
+	}
+	if repo == nil || repo.manifests[digest] == nil {
+		return "", nil, types.NewError(types.ErrorCodeManifestUnknown, "manifest unknown", map[string]string{"digest": digest})
+	}
+	return digest, repo.manifests[digest], nil
+}
+
+// setTag 让 tag 指向 digest 并维护反向索引。调用方需持有 mu
+func (d *inMemoryDriver) setTag(repo *memoryRepository, tagName, digest string) {
+	if previous, ok := repo.tags[tagName]; ok && previous != digest {
+		delete(repo.tagRefs[previous], tagName)
+	}
+	if repo.tagRefs[digest] == nil {
+		repo.tagRefs[digest] = make(map[string]bool)
+	}
+	repo.tagRefs[digest][tagName] = true
+	repo.tags[tagName] = digest
+}
+
+// removeTag 删除 tag 并维护反向索引。调用方需持有 mu
+func (d *inMemoryDriver) removeTag(repo *memoryRepository, tagName string) {
+	digest, ok := repo.tags[tagName]
+	if !ok {
+		return
+	}
+	delete(repo.tags, tagName)
+	delete(repo.tagRefs[digest], tagName)
+}
+
+// deleteRevision 删除 manifest、所有指向它的 tag，并将其从 subject 的 referrers 索引中移除。
+// 返回 manifest 的 subject（没有时为空），由调用方负责更新对应的 referrers tag。调用方需持有 mu
+func (d *inMemoryDriver) deleteRevision(repo *memoryRepository, digest string) string {
+	manifest := repo.manifests[digest]
+	for tagName := range repo.tagRefs[digest] {
+		delete(repo.tags, tagName)
+	}
+	delete(repo.tagRefs, digest)
+	delete(repo.manifests, digest)
+
+	subject := manifestSubject(manifest.content)
+	if subject == nil {
+		return ""
+	}
+	delete(repo.referrers[subject.Digest], digest)
+	return subject.Digest
+}
+
+// readRevision 读取仓库中的 manifest，实现 revisionFunc。调用方需持有 mu
+func (repo *memoryRepository) readRevision(digest string) (string, []byte, time.Time, error) {
+	manifest := repo.manifests[digest]
+	if manifest == nil {
+		return "", nil, time.Time{}, nil
+	}
+	return manifest.mediaType, manifest.content, manifest.createdAt, nil
+}
+
+// listReferrers 返回 referrers 索引中以 subject 为 subject 的 manifest，实现 referrersFunc。调用方需持有 mu
+func (repo *memoryRepository) listReferrers(subject string) ([]string, error) {
+	referrers := make([]string, 0, len(repo.referrers[subject]))
+	for digest := range repo.referrers[subject] {
+		referrers = append(referrers, digest)
+	}
+	return referrers, nil
+}
+
+// updateReferrersTag 根据 referrers 索引重新生成 subject 的 referrers tag。调用方需持有 mu
+func (d *inMemoryDriver) updateReferrersTag(repo *memoryRepository, subject string) error {
+	referrers, _ := repo.listReferrers(subject)
+	index, err := referrersIndex(referrers, repo.readRevision)
+	if err != nil {
+		return err
+	}
+	writeIndex := func(digest string, content []byte) error {
+		repo.manifests[digest] = &memoryManifest{content: content, mediaType: types.OCIIndexMediaType, createdAt: time.Now()}
+		return nil
+	}
+	setTag := func(tagName, digest string) error {
+		d.setTag(repo, tagName, digest)
+		return nil
+	}
+	removeTag := func(tagName string) error {
+		d.removeTag(repo, tagName)
+		return nil
+	}
+	return syncReferrersTag(subject, index, writeIndex, setTag, removeTag)
+}
+
+// linkedBlob 返回链接到仓库的 blob。调用方需持有 mu
+func (d *inMemoryDriver) linkedBlob(repoName, digest string) (*memoryBlob, error) {
+	if err := firstError(types.ValidateRepositoryName(repoName), types.ValidateDigest(digest)); err != nil {
+		return nil, err
+	}
+	repo := d.repository(repoName, false)
+	blob := d.blobs[digest]
+	if repo == nil || blob == nil {
+		return nil, types.NewError(types.ErrorCodeBlobUnknown, "blob unknown", map[string]string{"digest": digest})
+	}
+	if _, ok := repo.layers[digest]; !ok {
+		return nil, types.NewError(types.ErrorCodeBlobUnknown, "blob unknown", map[string]string{"digest": digest})
+	}
+	return blob, nil
+}
+
+// lockUpload 获取上传会话并持有其锁，会话不存在或已被移除时返回 BLOB_UPLOAD_UNKNOWN。
+// 返回用于释放锁的函数
+func (d *inMemoryDriver) lockUpload(repoName, uuid string) (*memoryUpload, func(), error) {
+	d.mu.RLock()
+	var upload *memoryUpload
+	if repo := d.repository(repoName, false); repo != nil {
+		upload = repo.uploads[uuid]
+	}
+	d.mu.RUnlock()
+
+	if upload != nil {
+		upload.mu.Lock()
+		if !upload.removed {
+			return upload, upload.mu.Unlock, nil
+		}
+		upload.mu.Unlock()
+	}
+	return nil, nil, types.NewError(types.ErrorCodeBlobUploadUnknown, "blob upload unknown", map[string]string{"uuid": uuid})
+}
+
+// removeUpload 移除上传会话。调用方需持有会话锁
+func (d *inMemoryDriver) removeUpload(repoName, uuid string, upload *memoryUpload) {
+	upload.removed = true
+	d.mu.Lock()
+	if repo := d.repository(repoName, false); repo != nil {
+		delete(repo.uploads, uuid)
+	}
+	d.mu.Unlock()
+}
+
+// appendUpload 将 body 追加到上传会话的数据末尾，同时增量计算哈希并更新已提交的长度。
+// hasRange 为 true 时校验 Content-Range 的起始位置与长度，不符时不写入并返回 RANGE_INVALID。
+// 调用方需持有会话锁
+func (d *inMemoryDriver) appendUpload(upload *memoryUpload, body io.Reader, hasRange bool, rangeFrom, rangeTo int64) error {
+	// 1. 校验 Range，流式上传（没有 Content-Range）直接追加到当前偏移量
+	currentSize := int64(len(upload.data))
+	if hasRange && rangeFrom != currentSize {
+		return types.NewError(types.ErrorCodeRangeInvalid, "invalid range", fmt.Sprintf("expected range start %d, got %d", currentSize, rangeFrom))
+	}
+
+	// 2. 先读取完整的数据块，长度校验通过后再追加，避免回滚哈希状态
+	var chunk []byte
+	if body != nil {
+		var err error
+		if chunk, err = io.ReadAll(body); err != nil {
+			return err
+		}
+	}
+	if hasRange && rangeTo-rangeFrom+1 != int64(len(chunk)) {
+		return types.NewError(types.ErrorCodeRangeInvalid, "invalid range", fmt.Sprintf("range length %d does not match content length %d", rangeTo-rangeFrom+1, len(chunk)))
+	}
+
+	// 3. 追加数据并更新哈希状态
+	upload.data = append(upload.data, chunk...)
+	upload.hasher.Write(chunk)
+
+	d.mu.Lock()
+	upload.size = len(upload.data)
+	d.mu.Unlock()
+	return nil
+}
+
+// --- Manifest API ---
+
+func (d *inMemoryDriver) GetManifest(params types.GetManifestParams) (*types.ManifestResponse, error) {
+	// 校验引用语法
+	if err := firstError(types.ValidateRepositoryName(params.RepositoryName), types.ValidateReference(params.Reference)); err != nil {
+		return nil, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	// 1. 解析引用并获取 manifest
+	_, manifest, err := d.lookupManifest(params.RepositoryName, params.Reference)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. 创建响应对象
+	return newManifestResponse(manifest.mediaType, manifest.content)
+}
+
+func (d *inMemoryDriver) PutManifest(params types.PutManifestParams) (*types.ManifestData, error) {
+	// 校验引用语法
+	if err := firstError(types.ValidateRepositoryName(params.RepositoryName), types.ValidateReference(params.Reference)); err != nil {
+		return nil, err
+	}
+
+	// 1. 读取 manifest 内容并计算 digest
+	content, err := types.ReadManifestContent(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	digest := calculateDigest(content)
+
+	// 2. 检测 digest 是否匹配
+	if strings.HasPrefix(params.Reference, "sha256:") && params.Reference != digest {
+		return nil, types.NewError(types.ErrorCodeDigestInvalid, "digest mismatch", nil)
+	}
+
+	// 3. 确定媒体类型，客户端提供的 Content-Type 是权威的
+	mediaType, validationType, err := resolveManifestMediaType(params.MediaType, content)
+	if err != nil {
+		return nil, err
+	}
+
+	// subject 的 digest 用于构造 referrers 索引，必须在任何写入之前校验
+	subject, err := parseManifestSubject(content)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// 4. 根据媒体类型校验 manifest 引用的内容是否都存在
+	repo := d.repository(params.RepositoryName, false)
+	manifestExists := func(digest string) (bool, error) {
+		return repo != nil && repo.manifests[digest] != nil, nil
+	}
+	blobExists := func(digest string) (bool, error) {
+		_, err := d.linkedBlob(params.RepositoryName, digest)
+		if regErr, ok := err.(types.RegistryError); ok {
+			switch regErr.Code {
+			case types.ErrorCodeBlobUnknown:
+				return false, nil
+			case types.ErrorCodeDigestInvalid:
+				return false, types.NewManifestInvalidError(fmt.Sprintf("invalid digest: %s", digest))
+			}
+		}
+		return err == nil, err
+	}
+	if err := validateManifestReferences(validationType, content, manifestExists, blobExists); err != nil {
+		return nil, err
+	}
+
+	// 5. 存储 manifest 内容及其媒体类型
+	repo = d.repository(params.RepositoryName, true)
+	repo.manifests[digest] = &memoryManifest{content: content, mediaType: mediaType, createdAt: time.Now()}
+
+	// 6. 如果 manifest 带有 subject，将其记录到 subject 的 referrers 索引中，并更新 sha256-<hex> tag
+	if subject != nil {
+		if repo.referrers[subject.Digest] == nil {
+			repo.referrers[subject.Digest] = make(map[string]bool)
+		}
+		repo.referrers[subject.Digest][digest] = true
+		if err := d.updateReferrersTag(repo, subject.Digest); err != nil {
+			return nil, err
+		}
+	}
+
+	// 7. 如果 reference 是 tag，则更新 tag
+	if !strings.HasPrefix(params.Reference, "sha256:") {
+		d.setTag(repo, params.Reference, digest)
+	}
+
+	// 8. 返回成功结果
+	result := &types.ManifestData{
+		Digest:    digest,
+		Location:  fmt.Sprintf("/v2/%s/manifests/%s", params.RepositoryName, digest),
+		MediaType: mediaType,
+	}
+	if subject != nil {
+		result.Subject = subject.Digest
+	}
+	return result, nil
+}
+
+func (d *inMemoryDriver) ManifestExists(params types.GetManifestParams) (*types.ManifestData, error) {
+	// 校验引用语法
+	if err := firstError(types.ValidateRepositoryName(params.RepositoryName), types.ValidateReference(params.Reference)); err != nil {
+		return nil, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	// 1. 解析引用并获取 manifest
+	digest, manifest, err := d.lookupManifest(params.RepositoryName, params.Reference)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. 构建并返回 ManifestData
+	return &types.ManifestData{
+		Digest:        digest,
+		Location:      fmt.Sprintf("/v2/%s/manifests/%s", params.RepositoryName, digest),
+		ContentLength: len(manifest.content),
+		MediaType:     manifest.mediaType,
+	}, nil
+}
+
+func (d *inMemoryDriver) DeleteManifest(params types.GetManifestParams) error {
+	// 校验引用语法
+	if err := firstError(types.ValidateRepositoryName(params.RepositoryName), types.ValidateReference(params.Reference)); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// 1. 解析引用，引用不存在时返回 MANIFEST_UNKNOWN
+	repo := d.repository(params.RepositoryName, false)
+	digest, err := d.resolveReference(repo, params.Reference)
+	if err != nil {
+		return err
+	}
+
+	// 2. 按 tag 删除只是取消标记：移除该 tag，manifest 以及指向它的其他 tag 保持不变
+	if !strings.HasPrefix(params.Reference, "sha256:") {
+		d.removeTag(repo, params.Reference)
+		return nil
+	}
+
+	// 3. 按 digest 删除时移除 manifest 本身以及指向它的 tag
+	if repo == nil || repo.manifests[digest] == nil {
+		return types.NewError(types.ErrorCodeManifestUnknown, "manifest unknown", map[string]string{"digest": digest})
+	}
+	subject := d.deleteRevision(repo, digest)
+
+	// 4. 如果 manifest 是某个 subject 的 referrer，更新 sha256-<hex> tag
+	if subject != "" {
+		return d.updateReferrersTag(repo, subject)
+	}
+	return nil
+}
+
+func (d *inMemoryDriver) ListTags(params types.ListTagsParams) (*types.TagsList, error) {
+	// 校验引用语法
+	if err := types.ValidateRepositoryName(params.RepositoryName); err != nil {
+		return nil, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	// 1. 检查仓库是否存在
+	repo := d.repository(params.RepositoryName, false)
+	if repo == nil {
+		return nil, types.NewNameUnknownError(params.RepositoryName)
+	}
+
+	// 2. 按字典序排序并分页
+	tags := make([]string, 0, len(repo.tags))
+	for tagName := range repo.tags {
+		tags = append(tags, tagName)
+	}
+	sort.Strings(tags)
+
+	page, hasMore := types.Paginate(tags, params.N, params.Last)
+	return &types.TagsList{
+		Name:    params.RepositoryName,
+		Tags:    page,
+		HasMore: hasMore,
+	}, nil
+}
+
+func (d *inMemoryDriver) GetReferrers(params types.GetReferrersParams) (*types.ManifestList, error) {
+	// 1. 校验引用语法并检查仓库是否存在
+	if err := firstError(types.ValidateRepositoryName(params.RepositoryName), types.ValidateDigest(params.Digest)); err != nil {
+		return nil, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	repo := d.repository(params.RepositoryName, false)
+	if repo == nil {
+		return nil, types.NewNameUnknownError(params.RepositoryName)
+	}
+
+	// 2. subject 不存在时返回空列表
+	referrers, _ := repo.listReferrers(params.Digest)
+	return referrersIndex(referrers, repo.readRevision)
+}
+
+// --- Catalog API ---
+
+func (d *inMemoryDriver) ListRepositories(params types.ListRepositoriesParams) (*types.Catalog, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	// 1. 有 manifest 或 blob 链接的仓库才出现在列表中，只有上传会话的仓库不算
+	repositories := []string{}
+	for repoName, repo := range d.repositories {
+		if len(repo.manifests) > 0 || len(repo.layers) > 0 {
+			repositories = append(repositories, repoName)
+		}
+	}
+
+	// 2. 按字典序排序并分页
+	sort.Strings(repositories)
+	page, hasMore := types.Paginate(repositories, params.N, params.Last)
+	return &types.Catalog{
+		Repositories: page,
+		HasMore:      hasMore,
+	}, nil
+}
+
+// --- Blob API ---
+
+func (d *inMemoryDriver) InitiateBlobUpload(params types.InitiateBlobUploadParams) (*types.InitiateBlobUploadResponse, error) {
+	// 校验引用语法
+	if err := types.ValidateRepositoryName(params.RepositoryName); err != nil {
+		return nil, err
+	}
+
+	// 检查是否是跨仓库挂载请求
+	if params.Mount != "" {
+		mountedStatus, err := d.mountBlob(params.RepositoryName, params.Mount, params.From)
+		if err == nil {
+			return &types.InitiateBlobUploadResponse{
+				Status:        mountedStatus,
+				MountedStatus: mountedStatus,
+			}, nil
+		}
+		if regErr, ok := err.(types.RegistryError); !ok || (regErr.Code != types.ErrorCodeBlobUnknown && regErr.Code != types.ErrorCodeDigestInvalid) {
+			return nil, err
+		}
+		// 如果无法挂载，则退回到上传流程
+	}
+
+	// 单请求上传流程：带有 digest 时请求体即为完整的 blob
+	if params.Digest != "" {
+		return d.initiateMonolithicUpload(params)
+	}
+
+	// 普通上传流程
+	return d.initiateRegularUpload(params.RepositoryName)
+}
+
+func (d *inMemoryDriver) mountBlob(repoName, digest, from string) (*types.BlobUploadMountedStatus, error) {
+	if err := types.ValidateDigest(digest); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// 1. 校验源 blob：必须链接到源仓库，未指定 from 时只接受已经链接到目标仓库的 blob
+	source := from
+	if source == "" {
+		source = repoName
+	}
+	blob, err := d.linkedBlob(source, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. 在目标仓库中创建链接
+	d.repository(repoName, true).layers[digest] = time.Now()
+
+	// 3. 创建 201 Mounted 状态
+	return &types.BlobUploadMountedStatus{
+		Location:      fmt.Sprintf("/v2/%s/blobs/%s", repoName, digest),
+		ContentLength: len(blob.data),
+		Digest:        digest,
+	}, nil
+}
+
+func (d *inMemoryDriver) initiateMonolithicUpload(params types.InitiateBlobUploadParams) (*types.InitiateBlobUploadResponse, error) {
+	// 1. 创建一个内部使用的上传会话
+	initiated, err := d.initiateRegularUpload(params.RepositoryName)
+	if err != nil {
+		return nil, err
+	}
+	uuidStr := initiated.InitiatedStatus.UUID
+
+	// 2. 写入完整内容并校验摘要
+	completed, err := d.CompleteBlobUpload(types.CompleteBlobUploadParams{
+		RepositoryName: params.RepositoryName,
+		UUID:           uuidStr,
+		Digest:         params.Digest,
+		Body:           params.Body,
+	})
+	if err != nil {
+		// 失败时清理上传会话，客户端不会知道这个会话的存在
+		if upload, unlock, lockErr := d.lockUpload(params.RepositoryName, uuidStr); lockErr == nil {
+			d.removeUpload(params.RepositoryName, uuidStr, upload)
+			unlock()
+		}
+		return nil, err
+	}
+
+	// 3. 创建 201 Created 状态
+	return &types.InitiateBlobUploadResponse{
+		Status:          completed,
+		CompletedStatus: completed,
+	}, nil
+}
+
+func (d *inMemoryDriver) initiateRegularUpload(repoName string) (*types.InitiateBlobUploadResponse, error) {
+	// 1. 生成一个新的 UUID 作为上传会话 ID
+	u, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+	uuidStr := u.String()
+
+	// 2. 创建上传会话
+	d.mu.Lock()
+	d.repository(repoName, true).uploads[uuidStr] = &memoryUpload{
+		hasher:    sha256.New(),
+		startedAt: time.Now(),
+	}
+	d.mu.Unlock()
+
+	// 3. 创建 202 Accepted 状态
+	initiatedStatus := &types.BlobUploadInitiatedStatus{
+		Location: fmt.Sprintf("/v2/%s/blobs/uploads/%s", repoName, uuidStr),
+		UUID:     uuidStr,
+		Range:    "0-0", // 初始范围
+	}
+	return &types.InitiateBlobUploadResponse{
+		Status:          initiatedStatus,
+		InitiatedStatus: initiatedStatus,
+	}, nil
+}
+
+func (d *inMemoryDriver) BlobExists(params types.GetBlobParams) (*types.BlobStatus, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	// 1. 检查 blob 是否链接到仓库
+	blob, err := d.linkedBlob(params.RepositoryName, params.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. 构建并返回 BlobStatus
+	return &types.BlobStatus{
+		Digest:        params.Digest,
+		ContentLength: len(blob.data),
+	}, nil
+}
+
+func (d *inMemoryDriver) RetrieveBlob(params types.GetBlobParams) (*types.BlobStatus, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	// 1. 检查 blob 是否链接到仓库
+	blob, err := d.linkedBlob(params.RepositoryName, params.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. 构建并返回 BlobStatus，blob 内容不可变，可以直接在其上创建 Reader
+	return &types.BlobStatus{
+		Digest:        params.Digest,
+		ContentLength: len(blob.data),
+		ContentType:   "application/octet-stream", // Blob 的标准 Content-Type
+		Reader:        readSeekNopCloser{bytes.NewReader(blob.data)},
+	}, nil
+}
+
+func (d *inMemoryDriver) GetBlobUploadStatus(params types.GetBlobParams) (*types.BlobUploadStatus, error) {
+	// 校验引用语法
+	if err := firstError(types.ValidateRepositoryName(params.RepositoryName), types.ValidateUploadUUID(params.UUID)); err != nil {
+		return nil, err
+	}
+
+	// 1. 读取上传会话已提交的长度，不等待进行中的追加
+	d.mu.RLock()
+	var upload *memoryUpload
+	if repo := d.repository(params.RepositoryName, false); repo != nil {
+		upload = repo.uploads[params.UUID]
+	}
+	var size int
+	if upload != nil {
+		size = upload.size
+	}
+	d.mu.RUnlock()
+	if upload == nil {
+		return nil, types.NewError(types.ErrorCodeBlobUploadUnknown, "blob upload unknown", map[string]string{"uuid": params.UUID})
+	}
+
+	// 2. 构建并返回状态
+	return &types.BlobUploadStatus{
+		UUID:     params.UUID,
+		Location: fmt.Sprintf("/v2/%s/blobs/uploads/%s", params.RepositoryName, params.UUID),
+		Range:    uploadRange(int64(size)),
+	}, nil
+}
+
+func (d *inMemoryDriver) CompleteBlobUpload(params types.CompleteBlobUploadParams) (*types.CompleteBlobUploadResponse, error) {
+	// 校验引用语法
+	if err := firstError(types.ValidateRepositoryName(params.RepositoryName), types.ValidateUploadUUID(params.UUID), types.ValidateDigest(params.Digest)); err != nil {
+		return nil, err
+	}
+
+	// 1. 获取上传会话并持有其锁
+	upload, unlock, err := d.lockUpload(params.RepositoryName, params.UUID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// 2. 将请求体中可能携带的最后一块数据追加到已上传的数据之后
+	if err := d.appendUpload(upload, params.Body, params.HasRange, params.RangeFrom, params.RangeTo); err != nil {
+		return nil, err
+	}
+	calculatedDigest := fmt.Sprintf("sha256:%x", upload.hasher.Sum(nil))
+
+	// 3. 校验摘要
+	if params.Digest != calculatedDigest {
+		return nil, types.NewError(types.ErrorCodeDigestInvalid, "digest mismatch", fmt.Sprintf("provided %s, calculated %s", params.Digest, calculatedDigest))
+	}
+
+	// 4. 存入全局 blob 存储并链接到仓库
+	d.mu.Lock()
+	now := time.Now()
+	if blob, ok := d.blobs[params.Digest]; ok {
+		blob.createdAt = now
+	} else {
+		d.blobs[params.Digest] = &memoryBlob{data: upload.data, createdAt: now}
+	}
+	d.repository(params.RepositoryName, true).layers[params.Digest] = now
+	d.mu.Unlock()
+
+	// 5. 移除上传会话
+	d.removeUpload(params.RepositoryName, params.UUID, upload)
+
+	// 6. 构建并返回成功响应
+	return &types.CompleteBlobUploadResponse{
+		Digest:        params.Digest,
+		Location:      fmt.Sprintf("/v2/%s/blobs/%s", params.RepositoryName, params.Digest),
+		ContentLength: len(upload.data),
+	}, nil
+}
+
+func (d *inMemoryDriver) UploadBlobChunk(params types.UploadBlobChunkParams) (*types.UploadBlobChunkResponse, error) {
+	// 校验引用语法
+	if err := firstError(types.ValidateRepositoryName(params.RepositoryName), types.ValidateUploadUUID(params.UUID)); err != nil {
+		return nil, err
+	}
+
+	// 1. 获取上传会话并持有其锁，同一会话的并发请求依次执行
+	upload, unlock, err := d.lockUpload(params.RepositoryName, params.UUID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// 2. 校验 Range 并追加数据
+	if err := d.appendUpload(upload, params.Body, params.HasRange, params.RangeFrom, params.RangeTo); err != nil {
+		return nil, err
+	}
+
+	// 3. 构建并返回响应
+	return &types.UploadBlobChunkResponse{
+		Location: fmt.Sprintf("/v2/%s/blobs/uploads/%s", params.RepositoryName, params.UUID),
+		Range:    uploadRange(int64(len(upload.data))),
+		UUID:     params.UUID,
+	}, nil
+}
+
+func (d *inMemoryDriver) CancelBlobUpload(params types.GetBlobParams) (int, error) {
+	// 校验引用语法
+	if err := firstError(types.ValidateRepositoryName(params.RepositoryName), types.ValidateUploadUUID(params.UUID)); err != nil {
+		return 0, err
+	}
+
+	// 1. 等待进行中的追加完成后删除会话
+	upload, unlock, err := d.lockUpload(params.RepositoryName, params.UUID)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	d.removeUpload(params.RepositoryName, params.UUID, upload)
+
+	// 2. 成功，返回 204 No Content 状态码
+	return 204, nil
+}
+
+func (d *inMemoryDriver) DeleteBlob(params types.GetBlobParams) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// 1. 检查 blob 是否链接到仓库
+	if _, err := d.linkedBlob(params.RepositoryName, params.Digest); err != nil {
+		return err
+	}
+
+	// 2. 只删除仓库对 blob 的链接，数据由垃圾回收在没有任何引用时清理
+	delete(d.repository(params.RepositoryName, false).layers, params.Digest)
+	return nil
+}
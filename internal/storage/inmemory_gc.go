@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"my_docker_registry/internal/types"
+	"sort"
+)
+
+// GarbageCollect 对内存中的数据执行标记-清除垃圾回收，标记规则与 fileSystemDriver 共用。
+// 整个过程持有写锁，不会与推送交错，宽限期仍用于保护已上传 layer 但尚未推送 manifest 的镜像。
+func (d *inMemoryDriver) GarbageCollect(options types.GarbageCollectOptions) (*types.GarbageCollectResult, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	result := &types.GarbageCollectResult{}
+	repoNames := make([]string, 0, len(d.repositories))
+	for repoName := range d.repositories {
+		repoNames = append(repoNames, repoName)
+	}
+	sort.Strings(repoNames)
+
+	// 1. 标记：收集所有保留的 manifest 引用的 blob，必要时删除不可达的 manifest
+	marked := make(map[string]bool)
+	for _, repoName := range repoNames {
+		if err := d.markRepository(repoName, options, marked, result); err != nil {
+			return nil, err
+		}
+	}
+
+	// 2. 清除：删除未被标记的 blob，宽限期内上传的 blob 视为已标记
+	digests := make([]string, 0, len(d.blobs))
+	for digest := range d.blobs {
+		digests = append(digests, digest)
+	}
+	sort.Strings(digests)
+	for _, digest := range digests {
+		blob := d.blobs[digest]
+		if marked[digest] {
+			continue
+		}
+		if withinGracePeriod(blob.createdAt, options.GracePeriod) {
+			marked[digest] = true
+			continue
+		}
+		result.BlobsDeleted = append(result.BlobsDeleted, digest)
+		result.BytesReclaimed += int64(len(blob.data))
+		if !options.DryRun {
+			delete(d.blobs, digest)
+		}
+	}
+
+	// 3. 删除仓库中指向已被清除的 blob 的链接
+	for _, repoName := range repoNames {
+		repo := d.repositories[repoName]
+		for digest := range repo.layers {
+			if marked[digest] {
+				continue
+			}
+			result.LayerLinksDeleted++
+			if !options.DryRun {
+				delete(repo.layers, digest)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// markRepository 标记仓库中保留的 manifest 引用的 blob。
+// 启用 DeleteUntagged 时只保留从 tag 可达的 manifest 以及宽限期内写入的 manifest。调用方需持有 mu
+func (d *inMemoryDriver) markRepository(repoName string, options types.GarbageCollectOptions, marked map[string]bool, result *types.GarbageCollectResult) error {
+	repo := d.repositories[repoName]
+
+	// 1. 确定需要保留的 manifest，不删除未打 tag 的 manifest 时全部保留
+	var keep map[string]bool
+	if options.DeleteUntagged {
+		var err error
+		if keep, err = reachableRevisions(repo.tags, repo.readRevision, repo.listReferrers); err != nil {
+			return err
+		}
+	}
+
+	// 2. 标记保留的 manifest 引用的 blob，删除其余的 manifest 并更新受影响的 referrers tag
+	revisions := make([]string, 0, len(repo.manifests))
+	for digest := range repo.manifests {
+		revisions = append(revisions, digest)
+	}
+	sort.Strings(revisions)
+
+	deleteRevision := func(digest string) (string, error) {
+		return d.deleteRevision(repo, digest), nil
+	}
+	updateReferrersTag := func(subject string) error {
+		return d.updateReferrersTag(repo, subject)
+	}
+	if err := markRevisions(repoName, revisions, keep, options, repo.readRevision, deleteRevision, updateReferrersTag, marked, result); err != nil {
+		return err
+	}
+
+	// 3. 标记宽限期内上传或挂载到仓库的 blob，它们的 manifest 可能还没有推送
+	for digest, linkedAt := range repo.layers {
+		if withinGracePeriod(linkedAt, options.GracePeriod) {
+			marked[digest] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"my_docker_registry/internal/types"
+	"time"
+)
+
+// PurgeUploads 删除所有仓库中创建时间早于 MaxAge 的上传会话
+func (d *inMemoryDriver) PurgeUploads(options types.PurgeUploadsOptions) (*types.PurgeUploadsResult, error) {
+	// 1. 收集过期的会话，删除时需要先获取会话锁，因此不能在持有 mu 时进行
+	type expiredUpload struct {
+		repoName, uuid string
+	}
+	var expired []expiredUpload
+	d.mu.RLock()
+	for repoName, repo := range d.repositories {
+		for uuid, upload := range repo.uploads {
+			if time.Since(upload.startedAt) >= options.MaxAge {
+				expired = append(expired, expiredUpload{repoName, uuid})
+			}
+		}
+	}
+	d.mu.RUnlock()
+
+	// 2. 等待进行中的追加完成后删除会话，期间已被完成或取消的会话跳过
+	result := &types.PurgeUploadsResult{}
+	for _, e := range expired {
+		upload, unlock, err := d.lockUpload(e.repoName, e.uuid)
+		if err != nil {
+			continue
+		}
+		result.UploadsPurged = append(result.UploadsPurged, e.repoName+"/"+e.uuid)
+		result.BytesReclaimed += int64(len(upload.data))
+		d.removeUpload(e.repoName, e.uuid, upload)
+		unlock()
+	}
+	return result, nil
+}
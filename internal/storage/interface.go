@@ -10,6 +10,11 @@ type StorageDriver interface {
 	PutManifest(params types.PutManifestParams) (*types.ManifestData, error)
 	ManifestExists(params types.GetManifestParams) (*types.ManifestData, error)
 	DeleteManifest(params types.GetManifestParams) error
+	ListTags(params types.ListTagsParams) (*types.TagsList, error)
+	GetReferrers(params types.GetReferrersParams) (*types.ManifestList, error)
+
+	// Catalog API
+	ListRepositories(params types.ListRepositoriesParams) (*types.Catalog, error)
 
 	// Blob API
 	InitiateBlobUpload(params types.InitiateBlobUploadParams) (*types.InitiateBlobUploadResponse, error)
@@ -19,4 +24,17 @@ type StorageDriver interface {
 	CompleteBlobUpload(params types.CompleteBlobUploadParams) (*types.CompleteBlobUploadResponse, error)
 	UploadBlobChunk(params types.UploadBlobChunkParams) (*types.UploadBlobChunkResponse, error)
 	CancelBlobUpload(params types.GetBlobParams) (int, error)
+	DeleteBlob(params types.GetBlobParams) error
+}
+
+// UploadPurger 由支持清理过期上传会话的存储驱动实现
+type UploadPurger interface {
+	// PurgeUploads 删除创建时间早于 MaxAge 的上传会话及其已上传的数据
+	PurgeUploads(options types.PurgeUploadsOptions) (*types.PurgeUploadsResult, error)
+}
+
+// GarbageCollector 由支持垃圾回收的存储驱动实现
+type GarbageCollector interface {
+	// GarbageCollect 标记所有 manifest 引用的 blob，并清除未被引用的 blob 以及指向它们的仓库链接
+	GarbageCollect(options types.GarbageCollectOptions) (*types.GarbageCollectResult, error)
 }
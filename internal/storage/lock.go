@@ -0,0 +1,108 @@
+package storage
+
+import "sync"
+
+// keyedMutex 为每个 key 提供一把互斥锁，用于串行化对同一资源（如同一个 subject 的 referrers）的修改。
+// 零值即可使用，不再被使用的锁会被自动回收。
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedMutexEntry
+}
+
+type keyedMutexEntry struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// Lock 获取 key 对应的锁，返回用于释放锁的函数
+func (k *keyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyedMutexEntry)
+	}
+	entry, ok := k.locks[key]
+	if !ok {
+		entry = &keyedMutexEntry{}
+		k.locks[key] = entry
+	}
+	entry.refs++
+	k.mu.Unlock()
+
+	entry.mu.Lock()
+	return func() {
+		entry.mu.Unlock()
+
+		k.mu.Lock()
+		entry.refs--
+		if entry.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// gcBarrier 是在线垃圾回收的写屏障。
+// 写入 blob 链接和 manifest 时共享持有，垃圾回收在清除阶段独占持有，两者不会交错；
+// 垃圾回收运行期间写入涉及的 blob 会被记录，清除阶段将它们视为已标记。
+// 屏障只在进程内生效，不覆盖共享同一个根目录的其他 registry 进程。
+type gcBarrier struct {
+	lock sync.RWMutex
+
+	mu      sync.Mutex
+	running bool
+	touched map[string]bool
+}
+
+// beginWrite 进入写入临界区并记录写入涉及的 blob，返回用于退出临界区的函数
+func (b *gcBarrier) beginWrite(digests ...string) func() {
+	b.lock.RLock()
+
+	b.mu.Lock()
+	if b.running {
+		for _, digest := range digests {
+			b.touched[digest] = true
+		}
+	}
+	b.mu.Unlock()
+
+	return b.lock.RUnlock
+}
+
+// start 开始一轮垃圾回收，已有垃圾回收在运行时返回 false。
+// 短暂地独占持有写屏障，等待开始之前进入的写入完成：这些写入在标记阶段可见，
+// 之后进入的写入都会被记录，不会有写入同时逃过标记和记录
+func (b *gcBarrier) start() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.running {
+		return false
+	}
+	b.running = true
+	b.touched = make(map[string]bool)
+	return true
+}
+
+// finish 结束本轮垃圾回收
+func (b *gcBarrier) finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.running = false
+	b.touched = nil
+}
+
+// beginSweep 等待进行中的写入完成并阻止新的写入，将标记阶段以来写入涉及的 blob 合并到 marked 中。
+// 返回用于结束清除阶段的函数
+func (b *gcBarrier) beginSweep(marked map[string]bool) func() {
+	b.lock.Lock()
+
+	b.mu.Lock()
+	for digest := range b.touched {
+		marked[digest] = true
+	}
+	b.mu.Unlock()
+
+	return b.lock.Unlock
+}
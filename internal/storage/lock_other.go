@@ -0,0 +1,8 @@
+//go:build !unix
+
+package storage
+
+// lockFile 在不支持 flock 的平台上什么也不做，只有进程内的锁生效
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGCBarrierStartWaitsForInFlightWrites(t *testing.T) {
+	var barrier gcBarrier
+	endWrite := barrier.beginWrite("sha256:a")
+
+	started := make(chan bool)
+	go func() { started <- barrier.start() }()
+
+	select {
+	case <-started:
+		t.Fatal("start returned while a write was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	endWrite()
+	if ok := <-started; !ok {
+		t.Fatal("start returned false")
+	}
+	defer barrier.finish()
+
+	if barrier.start() {
+		t.Fatal("second start returned true while garbage collection is running")
+	}
+}
+
+func TestGCBarrierSweepKeepsTouchedDigests(t *testing.T) {
+	var barrier gcBarrier
+	if !barrier.start() {
+		t.Fatal("start returned false")
+	}
+	defer barrier.finish()
+
+	barrier.beginWrite("sha256:a")()
+
+	marked := map[string]bool{}
+	endSweep := barrier.beginSweep(marked)
+	defer endSweep()
+	if !marked["sha256:a"] {
+		t.Fatalf("marked = %v, want sha256:a", marked)
+	}
+}
@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"my_docker_registry/internal/types"
+)
+
+// foreignLayerMediaType 是 Docker 的外部 layer 类型，其内容由 urls 指向的外部地址提供，不要求存在于本仓库
+const foreignLayerMediaType = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+
+// existsFunc 检查给定 digest 的内容是否存在于仓库中
+type existsFunc func(digest string) (bool, error)
+
+// referrersTagName 返回 OCI referrers tag schema 中 subject 对应的 tag，即 sha256-<hex>
+func referrersTagName(subject string) string {
+	return strings.Replace(subject, ":", "-", 1)
+}
+
+// parseManifestSubject 返回 manifest 中 subject 字段引用的 manifest，没有时返回 nil。
+// subject 的 digest 会被用于构造 referrers 索引的路径，非法时返回 MANIFEST_INVALID
+func parseManifestSubject(content []byte) (*types.BlobDescriptor, error) {
+	var base struct {
+		Subject *types.BlobDescriptor `json:"subject"`
+	}
+	if err := json.Unmarshal(content, &base); err != nil || base.Subject == nil || base.Subject.Digest == "" {
+		return nil, nil
+	}
+	if err := types.ValidateDigest(base.Subject.Digest); err != nil {
+		return nil, types.NewManifestInvalidError(fmt.Sprintf("invalid subject digest: %s", base.Subject.Digest))
+	}
+	return base.Subject, nil
+}
+
+// manifestSubject 返回已存储的 manifest 的 subject，没有或 digest 非法时返回 nil
+func manifestSubject(content []byte) *types.BlobDescriptor {
+	subject, err := parseManifestSubject(content)
+	if err != nil {
+		return nil
+	}
+	return subject
+}
+
+// referrerDescriptor 构建 referrers 列表中描述一个 manifest 的描述符。
+// artifactType 取 manifest 的 artifactType 字段，镜像清单没有该字段时取 config 的媒体类型。
+func referrerDescriptor(mediaType, digest string, content []byte) types.ManifestListDescriptor {
+	var base struct {
+		ArtifactType string `json:"artifactType"`
+		Config       *struct {
+			MediaType string `json:"mediaType"`
+		} `json:"config"`
+		Annotations map[string]string `json:"annotations"`
+	}
+	json.Unmarshal(content, &base)
+
+	artifactType := base.ArtifactType
+	if artifactType == "" && base.Config != nil {
+		artifactType = base.Config.MediaType
+	}
+	return types.ManifestListDescriptor{
+		MediaType:    mediaType,
+		Size:         int64(len(content)),
+		Digest:       digest,
+		ArtifactType: artifactType,
+		Annotations:  base.Annotations,
+	}
+}
+
+// referrersTagSubject 判断 tag 是否为 referrers tag schema 的 sha256-<hex>，是则返回对应的 subject
+func referrersTagSubject(tagName string) (string, bool) {
+	subject := strings.Replace(tagName, "-", ":", 1)
+	if !strings.HasPrefix(tagName, "sha256-") || types.ValidateDigest(subject) != nil {
+		return "", false
+	}
+	return subject, true
+}
+
+// manifestReferences 返回 manifest 直接引用的子 manifest 和 blob。
+// 自定义媒体类型的 artifact 按内容结构解析；外部 layer 不存储在本地，不计入 blob。
+// 返回的 digest 会被用于构造存储路径，非法的 digest 被忽略。
+func manifestReferences(mediaType string, content []byte) (manifests []string, blobs []string) {
+	if !types.IsManifestList(mediaType) && !types.IsImageManifest(mediaType) {
+		mediaType = types.DetectManifestMediaType(content)
+	}
+
+	switch {
+	case types.IsManifestList(mediaType):
+		var manifestList types.ManifestList
+		if err := json.Unmarshal(content, &manifestList); err != nil {
+			return nil, nil
+		}
+		for _, child := range manifestList.Manifests {
+			if types.ValidateDigest(child.Digest) == nil {
+				manifests = append(manifests, child.Digest)
+			}
+		}
+
+	case types.IsImageManifest(mediaType):
+		var manifest types.Manifest
+		if err := json.Unmarshal(content, &manifest); err != nil {
+			return nil, nil
+		}
+		if types.ValidateDigest(manifest.Config.Digest) == nil {
+			blobs = append(blobs, manifest.Config.Digest)
+		}
+		for _, layer := range manifest.Layers {
+			if len(layer.URLs) > 0 && layer.MediaType == foreignLayerMediaType {
+				continue
+			}
+			if types.ValidateDigest(layer.Digest) == nil {
+				blobs = append(blobs, layer.Digest)
+			}
+		}
+	}
+	return manifests, blobs
+}
+
+// newManifestResponse 构建 GetManifest 的响应，并根据内容结构解析（媒体类型可能是客户端自定义的）
+func newManifestResponse(mediaType string, content []byte) (*types.ManifestResponse, error) {
+	response := &types.ManifestResponse{
+		Content:   content,
+		MediaType: mediaType,
+	}
+
+	switch types.DetectManifestMediaType(content) {
+	case types.ManifestV2MediaType, types.OCIManifestMediaType:
+		var manifest types.Manifest
+		if err := json.Unmarshal(content, &manifest); err != nil {
+			return nil, types.NewError(types.ErrorCodeManifestInvalid, "failed to parse manifest", err.Error())
+		}
+		response.Manifest = &manifest
+	case types.ManifestListV2MediaType, types.OCIIndexMediaType:
+		var manifestList types.ManifestList
+		if err := json.Unmarshal(content, &manifestList); err != nil {
+			return nil, types.NewError(types.ErrorCodeManifestInvalid, "failed to parse manifest list", err.Error())
+		}
+		response.ManifestList = &manifestList
+	}
+
+	return response, nil
+}
+
+// resolveManifestMediaType 确定上传的 manifest 的媒体类型。
+// 客户端通过 Content-Type 提供的类型是权威的，但必须与 manifest 中的 mediaType 字段一致；
+// 未提供或只是通用的 application/json 时根据内容检测。manifest 没有 mediaType 字段时，
+// 其结构必须与 Content-Type 声明的 manifest list / 镜像清单类型一致。
+// 返回需要保存并在 GET/HEAD 时返回的媒体类型，以及用于校验引用的媒体类型（自定义类型按内容结构校验）。
+func resolveManifestMediaType(contentType string, content []byte) (string, string, error) {
+	detected := types.DetectManifestMediaType(content)
+	if contentType == "" || contentType == "application/json" {
+		return detected, detected, nil
+	}
+
+	var base struct {
+		MediaType string `json:"mediaType"`
+	}
+	if err := json.Unmarshal(content, &base); err != nil {
+		return "", "", types.NewError(types.ErrorCodeManifestInvalid, "failed to parse manifest", err.Error())
+	}
+	if base.MediaType != "" && base.MediaType != contentType {
+		return "", "", types.NewManifestInvalidError(fmt.Sprintf("mediaType %q in manifest does not match Content-Type %q", base.MediaType, contentType))
+	}
+
+	if types.IsManifestList(contentType) || types.IsImageManifest(contentType) {
+		// 没有 mediaType 字段时检测到的是内容结构，镜像清单不能以 index 类型上传，反之亦然
+		if types.IsManifestList(contentType) != types.IsManifestList(detected) {
+			return "", "", types.NewManifestInvalidError(fmt.Sprintf("manifest structure does not match Content-Type %q", contentType))
+		}
+		return contentType, contentType, nil
+	}
+	return contentType, detected, nil
+}
+
+// validateManifestReferences 根据媒体类型校验 manifest 引用的内容是否都存在于仓库中。
+// 对于 manifest list / OCI index，校验每个子 manifest；对于镜像清单，校验 config 和所有 layer。
+// 缺失的内容会汇总为一个 MANIFEST_BLOB_UNKNOWN 错误返回，digest 非法时返回 MANIFEST_INVALID。
+func validateManifestReferences(mediaType string, content []byte, manifestExists, blobExists existsFunc) error {
+	var missing []string
+	check := func(digest string, exists existsFunc) error {
+		if digest == "" {
+			return types.NewManifestInvalidError("descriptor is missing digest")
+		}
+		// digest 会被用于构造存储路径，非法的 digest 不能交给 exists 检查
+		if err := types.ValidateDigest(digest); err != nil {
+			return types.NewManifestInvalidError(fmt.Sprintf("invalid digest: %s", digest))
+		}
+		ok, err := exists(digest)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			missing = append(missing, digest)
+		}
+		return nil
+	}
+
+	switch {
+	case types.IsManifestList(mediaType):
+		var manifestList types.ManifestList
+		if err := json.Unmarshal(content, &manifestList); err != nil {
+			return types.NewError(types.ErrorCodeManifestInvalid, "failed to parse manifest list", err.Error())
+		}
+		for _, child := range manifestList.Manifests {
+			if err := check(child.Digest, manifestExists); err != nil {
+				return err
+			}
+		}
+
+	case types.IsImageManifest(mediaType):
+		var manifest types.Manifest
+		if err := json.Unmarshal(content, &manifest); err != nil {
+			return types.NewError(types.ErrorCodeManifestInvalid, "failed to parse manifest", err.Error())
+		}
+		if err := check(manifest.Config.Digest, blobExists); err != nil {
+			return err
+		}
+		for _, layer := range manifest.Layers {
+			// 外部 layer 不存储在本仓库中
+			if len(layer.URLs) > 0 && layer.MediaType == foreignLayerMediaType {
+				continue
+			}
+			if err := check(layer.Digest, blobExists); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return types.NewManifestInvalidError(fmt.Sprintf("unsupported manifest media type: %s", mediaType))
+	}
+
+	if len(missing) > 0 {
+		return types.NewManifestBlobUnknownError(missing)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package storage
+
+import "fmt"
+
+// uploadRange 返回上传会话已接收数据的范围，用于 Range 响应头
+func uploadRange(size int64) string {
+	if size == 0 {
+		return "0-0"
+	}
+	return fmt.Sprintf("0-%d", size-1)
+}
@@ -15,7 +15,7 @@ type BlobStatus struct {
 	ContentLength int
 	Digest        string
 	ContentType   string
-	Reader        io.ReadCloser // 用于 GET 请求时输出文件内容
+	Reader        io.ReadSeekCloser // 用于 GET 请求时输出文件内容，支持 Range 请求时的定位
 }
 
 // GetBlobUploadStatus 返回参数
@@ -30,7 +30,10 @@ type CompleteBlobUploadParams struct {
 	RepositoryName string
 	UUID           string
 	Digest         string
-	Data           []byte
+	Body           io.Reader // 请求体中可能携带的最后一块数据，流式追加
+	HasRange       bool      // 最后一块数据是否带有 Content-Range
+	RangeFrom      int64     // 从 Content-Range 解析出的起始字节
+	RangeTo        int64     // 从 Content-Range 解析出的结束字节
 }
 
 // CompleteBlobUpload 返回参数
@@ -40,20 +43,27 @@ type CompleteBlobUploadResponse struct {
 	ContentLength int
 }
 
+func (s *CompleteBlobUploadResponse) GetStatusCode() int {
+	return 201 // 201 Created
+}
+
 // InitiateBlobUpload 接受参数
 type InitiateBlobUploadParams struct {
 	RepositoryName string
 	Mount          string
 	From           string
+	Digest         string    // 单请求上传时 blob 的摘要
+	Body           io.Reader // 单请求上传时的完整 blob 内容
 }
 
 // UploadBlobChunk 接受参数
 type UploadBlobChunkParams struct {
 	RepositoryName string
 	UUID           string
-	Content        []byte // 来自请求体
-	RangeFrom      int64  // 从 Content-Range 解析出的起始字节
-	RangeTo        int64  // 从 Content-Range 解析出的结束字节
+	Body           io.Reader // 来自请求体，流式写入磁盘
+	HasRange       bool      // 请求是否带有 Content-Range，流式上传时为 false
+	RangeFrom      int64     // 从 Content-Range 解析出的起始字节
+	RangeTo        int64     // 从 Content-Range 解析出的结束字节
 }
 
 // UploadBlobChunk 返回参数
@@ -63,14 +73,17 @@ type UploadBlobChunkResponse struct {
 	UUID     string
 }
 
-// blob 标识符
+// blob 标识符，URLs、Annotations 和 ArtifactType 仅出现在 OCI 描述符中
 type BlobDescriptor struct {
-	MediaType string `json:"mediaType"`
-	Size      int64  `json:"size"`
-	Digest    string `json:"digest"`
+	MediaType    string            `json:"mediaType"`
+	Size         int64             `json:"size"`
+	Digest       string            `json:"digest"`
+	URLs         []string          `json:"urls,omitempty"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
 }
 
-// 201 when mouted, 202 when initiated
+// 201 when mouted or uploaded monolithically, 202 when initiated
 type InitiateBlobUploadResponse struct {
 	// 通用状态接口
 	Status interface {
@@ -80,6 +93,7 @@ type InitiateBlobUploadResponse struct {
 	// 具体状态
 	MountedStatus   *BlobUploadMountedStatus
 	InitiatedStatus *BlobUploadInitiatedStatus
+	CompletedStatus *CompleteBlobUploadResponse
 }
 
 // when 201
@@ -18,13 +18,16 @@ const (
 	ErrorCodeBlobUploadUnknown ErrorCode = "BLOB_UPLOAD_UNKNOWN"
 
 	// 400 Bad Request
-	ErrorCodeDigestInvalid     ErrorCode = "DIGEST_INVALID"
-	ErrorCodeSizeInvalid       ErrorCode = "SIZE_INVALID"
-	ErrorCodeManifestInvalid   ErrorCode = "MANIFEST_INVALID"
-	ErrorCodeBlobUploadInvalid ErrorCode = "BLOB_UPLOAD_INVALID"
-	ErrorCodeNameInvalid       ErrorCode = "NAME_INVALID"
-	ErrorCodeUnsupported       ErrorCode = "UNSUPPORTED"
-	ErrorCodeRangeInvalid      ErrorCode = "RANGE_INVALID"
+	ErrorCodeManifestBlobUnknown ErrorCode = "MANIFEST_BLOB_UNKNOWN"
+	ErrorCodeDigestInvalid       ErrorCode = "DIGEST_INVALID"
+	ErrorCodeSizeInvalid         ErrorCode = "SIZE_INVALID"
+	ErrorCodeManifestInvalid     ErrorCode = "MANIFEST_INVALID"
+	ErrorCodeBlobUploadInvalid   ErrorCode = "BLOB_UPLOAD_INVALID"
+	ErrorCodeNameInvalid         ErrorCode = "NAME_INVALID"
+	ErrorCodeTagInvalid          ErrorCode = "TAG_INVALID"
+	ErrorCodeUnsupported         ErrorCode = "UNSUPPORTED"
+	ErrorCodeRangeInvalid        ErrorCode = "RANGE_INVALID"
+	ErrorCodePaginationInvalid   ErrorCode = "PAGINATION_NUMBER_INVALID"
 )
 
 // RegistryError defines the structure for a single error.
@@ -112,6 +115,15 @@ func NewNameInvalidError(name string) RegistryError {
 	}
 }
 
+// NewTagInvalidError creates a 400 error for invalid tag
+func NewTagInvalidError(tag string) RegistryError {
+	return RegistryError{
+		Code:    ErrorCodeTagInvalid,
+		Message: "invalid tag",
+		Detail:  map[string]string{"tag": tag},
+	}
+}
+
 // NewManifestInvalidError creates a 400 error for invalid manifest
 func NewManifestInvalidError(reason string) RegistryError {
 	return RegistryError{
@@ -147,3 +159,22 @@ func NewBlobUploadInvalidError(reason string) RegistryError {
 		Detail:  map[string]string{"reason": reason},
 	}
 }
+
+// NewPaginationInvalidError creates a 400 error for invalid pagination number
+func NewPaginationInvalidError(n string) RegistryError {
+	return RegistryError{
+		Code:    ErrorCodePaginationInvalid,
+		Message: "invalid number of results requested",
+		Detail:  map[string]string{"n": n},
+	}
+}
+
+// NewManifestBlobUnknownError creates a 400 error for blobs or child manifests
+// referenced by a manifest that are unknown to the repository
+func NewManifestBlobUnknownError(digests []string) RegistryError {
+	return RegistryError{
+		Code:    ErrorCodeManifestBlobUnknown,
+		Message: "manifest references a manifest or blob unknown to registry",
+		Detail:  map[string][]string{"digests": digests},
+	}
+}
@@ -0,0 +1,20 @@
+package types
+
+import "time"
+
+// GarbageCollect 接受参数
+type GarbageCollectOptions struct {
+	DryRun         bool // 只统计可以回收的内容，不实际删除
+	DeleteUntagged bool // 同时删除没有 tag 可达的 manifest
+	// GracePeriod 内新上传或链接的 blob、新写入的 manifest 不会被回收，
+	// 避免在线回收时删除已上传 layer 但尚未推送 manifest 的镜像
+	GracePeriod time.Duration
+}
+
+// GarbageCollect 返回参数
+type GarbageCollectResult struct {
+	ManifestsDeleted  []string // 被删除的 manifest，格式为 <name>@<digest>
+	BlobsDeleted      []string // 被删除的 blob 摘要
+	LayerLinksDeleted int      // 被删除的仓库 blob 链接数量
+	BytesReclaimed    int64    // 回收的字节数，包括 manifest 和 blob
+}
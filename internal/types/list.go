@@ -0,0 +1,43 @@
+package types
+
+// ListTags 接受参数
+type ListTagsParams struct {
+	RepositoryName string
+	N              int    // 单页最大条目数，小于 0 表示不限制
+	Last           string // 上一页的最后一个 tag，结果从它之后开始
+}
+
+// ListTags 返回参数，同时也是 GET /v2/{name}/tags/list 的响应体
+type TagsList struct {
+	Name    string   `json:"name"`
+	Tags    []string `json:"tags"`
+	HasMore bool     `json:"-"` // 是否还有下一页
+}
+
+// ListRepositories 接受参数
+type ListRepositoriesParams struct {
+	N    int    // 单页最大条目数，小于 0 表示不限制
+	Last string // 上一页的最后一个仓库名，结果从它之后开始
+}
+
+// ListRepositories 返回参数，同时也是 GET /v2/_catalog 的响应体
+type Catalog struct {
+	Repositories []string `json:"repositories"`
+	HasMore      bool     `json:"-"` // 是否还有下一页
+}
+
+// Paginate 对已排序的条目按 last 和 n 进行分页，返回当前页以及是否还有下一页
+func Paginate(entries []string, n int, last string) ([]string, bool) {
+	start := 0
+	if last != "" {
+		for start < len(entries) && entries[start] <= last {
+			start++
+		}
+	}
+	entries = entries[start:]
+
+	if n < 0 || n >= len(entries) {
+		return entries, false
+	}
+	return entries[:n], true
+}
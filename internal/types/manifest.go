@@ -4,29 +4,54 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"io"
 )
 
+// MaxManifestSize 是允许上传的 manifest 的最大字节数，与参考实现 distribution 一致
+const MaxManifestSize = 4 << 20
+
 // Content-Type
 const (
 	ManifestV2MediaType     = "application/vnd.docker.distribution.manifest.v2+json"
 	ManifestListV2MediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
 	ConfigV1MediaType       = "application/vnd.docker.container.image.v1+json"
 	LayerMediaType          = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+
+	// OCI image spec
+	OCIManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	OCIIndexMediaType    = "application/vnd.oci.image.index.v1+json"
+	OCIConfigMediaType   = "application/vnd.oci.image.config.v1+json"
+	OCILayerMediaType    = "application/vnd.oci.image.layer.v1.tar+gzip"
+	OCIEmptyMediaType    = "application/vnd.oci.empty.v1+json"
 )
 
+// IsManifestList 判断媒体类型是否为引用其他 manifest 的列表（Docker manifest list 或 OCI image index）
+func IsManifestList(mediaType string) bool {
+	return mediaType == ManifestListV2MediaType || mediaType == OCIIndexMediaType
+}
+
+// IsImageManifest 判断媒体类型是否为引用 config 和 layers 的镜像清单（Docker v2 或 OCI image manifest）
+func IsImageManifest(mediaType string) bool {
+	return mediaType == ManifestV2MediaType || mediaType == OCIManifestMediaType
+}
+
 // 查询 Manifest 通过 name 和 reference
 type GetManifestParams struct {
 	RepositoryName string
 	Reference      string
 }
 
-// Manifest 代表一个 Docker 镜像清单 (v2)。
+// Manifest 代表一个 Docker 镜像清单 (v2) 或 OCI image manifest。
 // 这是与 Registry API 交互时的核心数据结构。
+// ArtifactType、Subject 和 Annotations 仅出现在 OCI manifest 中。
 type Manifest struct {
-	SchemaVersion int              `json:"schemaVersion"`
-	MediaType     string           `json:"mediaType"`
-	Config        BlobDescriptor   `json:"config"`
-	Layers        []BlobDescriptor `json:"layers"`
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        BlobDescriptor    `json:"config"`
+	Layers        []BlobDescriptor  `json:"layers"`
+	Subject       *BlobDescriptor   `json:"subject,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
 }
 
 // PutManifestParams 封装了 PutManifest 方法所需的所有参数。
@@ -34,36 +59,45 @@ type PutManifestParams struct {
 	RepositoryName string
 	Reference      string
 	MediaType      string
-	Content        []byte
+	Body           io.Reader // manifest 内容，最多读取 MaxManifestSize 字节
 }
 
 type Platform struct {
-	Architecture string `json:"architecture"`
-	OS           string `json:"os"`
-	Variant      string `json:"variant,omitempty"`
+	Architecture string   `json:"architecture"`
+	OS           string   `json:"os"`
+	OSVersion    string   `json:"os.version,omitempty"`
+	OSFeatures   []string `json:"os.features,omitempty"`
+	Variant      string   `json:"variant,omitempty"`
+	Features     []string `json:"features,omitempty"`
 }
 
-// ManifestList 代表一个多架构 Docker 镜像清单列表 (v2)
+// ManifestList 代表一个多架构 Docker 镜像清单列表 (v2) 或 OCI image index
+// ArtifactType、Subject 和 Annotations 仅出现在 OCI image index 中。
 type ManifestList struct {
 	SchemaVersion int                      `json:"schemaVersion"`
-	MediaType     string                   `json:"mediaType"`
+	MediaType     string                   `json:"mediaType,omitempty"`
+	ArtifactType  string                   `json:"artifactType,omitempty"`
 	Manifests     []ManifestListDescriptor `json:"manifests"`
+	Subject       *BlobDescriptor          `json:"subject,omitempty"`
+	Annotations   map[string]string        `json:"annotations,omitempty"`
 }
 
 // ManifestListDescriptor 描述了清单列表中的一个清单
 type ManifestListDescriptor struct {
-	MediaType string    `json:"mediaType"`
-	Size      int64     `json:"size"`
-	Digest    string    `json:"digest"`
-	Platform  *Platform `json:"platform,omitempty"`
+	MediaType    string            `json:"mediaType"`
+	Size         int64             `json:"size"`
+	Digest       string            `json:"digest"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Platform     *Platform         `json:"platform,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
 }
 
 // ManifestResponse 包含 manifest 的原始内容和解析后的数据
 type ManifestResponse struct {
 	Content      []byte        // 原始 JSON 内容
 	MediaType    string        // 检测到的媒体类型
-	Manifest     *Manifest     // 解析后的 v2 manifest (如果适用)
-	ManifestList *ManifestList // 解析后的 manifest list (如果适用)
+	Manifest     *Manifest     // 解析后的 v2 / OCI manifest (如果适用)
+	ManifestList *ManifestList // 解析后的 manifest list / OCI index (如果适用)
 }
 
 // 接受 PutImageManifest 和 CheckImageManifest 返回参数
@@ -72,6 +106,13 @@ type ManifestData struct {
 	Location      string
 	ContentLength int    //always 0
 	MediaType     string // 添加媒体类型字段
+	Subject       string // manifest 中 subject 的摘要，用于设置 OCI-Subject 响应头
+}
+
+// 查询 referrers 通过 name 和 subject digest
+type GetReferrersParams struct {
+	RepositoryName string
+	Digest         string
 }
 
 // DetectManifestMediaType 根据 manifest 内容检测并返回正确的 Content-Type
@@ -79,6 +120,11 @@ func DetectManifestMediaType(content []byte) string {
 	// 尝试解析为通用结构来检查 mediaType 字段
 	var base struct {
 		MediaType string `json:"mediaType"`
+		Config    *struct {
+			MediaType string `json:"mediaType"`
+		} `json:"config"`
+		Layers    []json.RawMessage `json:"layers"`
+		Manifests []json.RawMessage `json:"manifests"`
 	}
 
 	if err := json.Unmarshal(content, &base); err != nil {
@@ -88,28 +134,45 @@ func DetectManifestMediaType(content []byte) string {
 
 	// 根据 mediaType 字段判断类型
 	switch base.MediaType {
-	case ManifestListV2MediaType:
-		return ManifestListV2MediaType
-	case ManifestV2MediaType:
+	case ManifestListV2MediaType, ManifestV2MediaType, OCIIndexMediaType, OCIManifestMediaType:
+		return base.MediaType
+	case "":
+		// Docker 的两种格式都要求带有 mediaType 字段，OCI 格式中该字段只是推荐的，
+		// 因此缺少 mediaType 时根据结构判断为 OCI 格式
+		if base.Manifests != nil {
+			return OCIIndexMediaType
+		}
+		if base.Config != nil && base.Config.MediaType == ConfigV1MediaType {
+			return ManifestV2MediaType
+		}
+		if base.Config != nil || base.Layers != nil {
+			return OCIManifestMediaType
+		}
 		return ManifestV2MediaType
 	default:
-		// 对于没有明确 mediaType 或未知类型，尝试解析结构判断
-		var manifestV2 Manifest
-		var manifestList ManifestList
-
-		if err := json.Unmarshal(content, &manifestList); err == nil && len(manifestList.Manifests) > 0 {
+		// 对于未知类型，尝试解析结构判断
+		if len(base.Manifests) > 0 {
 			return ManifestListV2MediaType
 		}
 
-		if err := json.Unmarshal(content, &manifestV2); err == nil && len(manifestV2.Layers) > 0 {
-			return ManifestV2MediaType
-		}
-
 		// 默认情况
 		return ManifestV2MediaType
 	}
 }
 
+// ReadManifestContent 从 r 中读取 manifest 内容，超过 MaxManifestSize 时返回 MANIFEST_INVALID，
+// 这样无论客户端上传什么，内存占用都有上限
+func ReadManifestContent(r io.Reader) ([]byte, error) {
+	content, err := io.ReadAll(io.LimitReader(r, MaxManifestSize+1))
+	if err != nil {
+		return nil, NewManifestInvalidError("Failed to read manifest")
+	}
+	if len(content) > MaxManifestSize {
+		return nil, NewManifestInvalidError(fmt.Sprintf("manifest exceeds maximum size of %d bytes", MaxManifestSize))
+	}
+	return content, nil
+}
+
 // CalculateDigest 计算内容的 SHA256 摘要
 func CalculateDigest(content []byte) string {
 	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
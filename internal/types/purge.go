@@ -0,0 +1,14 @@
+package types
+
+import "time"
+
+// PurgeUploads 接受参数
+type PurgeUploadsOptions struct {
+	MaxAge time.Duration // 上传会话自创建起的最长存活时间
+}
+
+// PurgeUploads 返回参数
+type PurgeUploadsResult struct {
+	UploadsPurged  []string // 被删除的上传会话，格式为 <name>/<uuid>
+	BytesReclaimed int64    // 被删除的已上传数据的字节数
+}
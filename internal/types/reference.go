@@ -0,0 +1,81 @@
+package types
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// 引用语法，参考 distribution spec 和 distribution/reference 包
+var (
+	// repositoryNameRegexp 匹配仓库名：由 / 分隔的若干组件，每个组件由小写字母和数字组成，
+	// 中间可以用 .、_、__ 或若干个 - 连接
+	repositoryNameRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+
+	// tagRegexp 匹配 tag：最长 128 个字符，不能以 . 或 - 开头
+	tagRegexp = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+
+	// digestRegexp 匹配 digest 的通用格式 algorithm:encoded
+	digestRegexp = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+
+	// sha256HexRegexp 匹配 sha256 digest 的编码部分
+	sha256HexRegexp = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+	// uploadUUIDRegexp 匹配上传会话 ID
+	uploadUUIDRegexp = regexp.MustCompile(`^[a-f0-9]{8}-[a-f0-9]{4}-[a-f0-9]{4}-[a-f0-9]{4}-[a-f0-9]{12}$`)
+)
+
+// MaxRepositoryNameLength 是仓库名的最大长度
+const MaxRepositoryNameLength = 255
+
+// ValidateRepositoryName 校验仓库名，不合法时返回 NAME_INVALID
+func ValidateRepositoryName(name string) error {
+	if len(name) > MaxRepositoryNameLength || !repositoryNameRegexp.MatchString(name) {
+		return NewNameInvalidError(name)
+	}
+	return nil
+}
+
+// ValidateTag 校验 tag，不合法时返回 TAG_INVALID
+func ValidateTag(tag string) error {
+	if !tagRegexp.MatchString(tag) {
+		return NewTagInvalidError(tag)
+	}
+	return nil
+}
+
+// ValidateDigest 校验 digest 的格式，目前只支持 sha256，不合法时返回 DIGEST_INVALID
+func ValidateDigest(digest string) error {
+	if !digestRegexp.MatchString(digest) {
+		return NewError(ErrorCodeDigestInvalid, "invalid digest", map[string]string{"digest": digest})
+	}
+	algorithm, encoded, _ := strings.Cut(digest, ":")
+	if algorithm != "sha256" {
+		return NewError(ErrorCodeDigestInvalid, fmt.Sprintf("unsupported digest algorithm: %s", algorithm), map[string]string{"digest": digest})
+	}
+	if !sha256HexRegexp.MatchString(encoded) {
+		return NewError(ErrorCodeDigestInvalid, "invalid digest", map[string]string{"digest": digest})
+	}
+	return nil
+}
+
+// IsDigestReference 判断 manifest 引用是 digest 还是 tag（tag 中不允许出现 :）
+func IsDigestReference(reference string) bool {
+	return strings.Contains(reference, ":")
+}
+
+// ValidateReference 校验 manifest 引用，按 digest 或 tag 的规则分别校验
+func ValidateReference(reference string) error {
+	if IsDigestReference(reference) {
+		return ValidateDigest(reference)
+	}
+	return ValidateTag(reference)
+}
+
+// ValidateUploadUUID 校验上传会话 ID，格式不合法的会话必然不存在，返回 BLOB_UPLOAD_UNKNOWN
+func ValidateUploadUUID(uuid string) error {
+	if !uploadUUIDRegexp.MatchString(uuid) {
+		return NewBlobUploadUnknownError(uuid)
+	}
+	return nil
+}